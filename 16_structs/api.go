@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"learngo/internal/money"
+)
+
+/*
+REST API over orders and customers with plain net/http
+POST /customers                 create customer
+GET  /customers                 list customers (paginated)
+GET  /customers/{id}            one customer
+POST /orders                    create order
+GET  /orders?status=&customerId=&limit=&offset=   list orders
+GET  /orders/{id}               one order
+GET  /orders/{id}/events        everything that happened to the order (only when the api has an event log)
+POST /orders/{id}/status        {"status":"Shipped","by":"warehouse","version":2}
+POST /orders/{id}/cancel        {"by":"manish","version":2}
+
+every error goes out in the same problem+json shape (RFC 9457) so clients have one way to read errors
+version in the body is optional, if sent it is checked (optimistic locking from the repository)
+go run . serve listens on localhost:8080 so it is not open to the whole network, ORDER_API_ADDR=":9000" changes it
+*/
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxRequestBody  = 1 << 20
+)
+
+type orderAPI struct {
+	orders    OrderRepository
+	customers *customerStore
+	events    *orderEventLog //same log the repository writes to, nil -> no /events route
+	currency  string         //used when request does not send currency
+	clock     Clock          //createdAt of new customers and orders
+}
+
+// apiAddr is where "go run . serve" listens
+func apiAddr() string {
+	if addr := os.Getenv("ORDER_API_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:8080"
+}
+
+func newOrderAPI(orders OrderRepository, customers *customerStore, events *orderEventLog) *orderAPI {
+	return &orderAPI{orders: orders, customers: customers, events: events, currency: "INR", clock: realClock{}}
+}
+
+// newServeAPI wires the repository and the api to one event log, used by "go run . serve"
+func newServeAPI() *orderAPI {
+	events := newOrderEventLog(10, realClock{})
+	return newOrderAPI(newMemoryOrderRepository(orderRepositoryOptions{events: events, clock: realClock{}}), newCustomerStore(), events)
+}
+
+// problem is the error body, errors holds per field validation messages
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, fields ...fieldError) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   fields,
+	}
+	if len(fields) > 0 {
+		p.Type = "/problems/validation"
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// maps repository and lifecycle errors to http status
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var transitionErr *transitionError
+	var invalid *validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeProblem(w, r, http.StatusUnprocessableEntity, "request is not valid", invalid.fields...)
+	case errors.Is(err, errOrderNotFound), errors.Is(err, errCustomerNotFound):
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, errOrderExists), errors.Is(err, errCustomerExists), errors.Is(err, errVersionConflict):
+		writeProblem(w, r, http.StatusConflict, err.Error())
+	case errors.As(err, &transitionErr):
+		writeProblem(w, r, http.StatusConflict, err.Error())
+	default:
+		writeProblem(w, r, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// strict decoding, unknown fields are an error so typos like "stauts" dont pass silently
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decodeJSON(w, r, v, false)
+}
+
+// decodeOptionalBody is decodeBody where no body at all is fine too, v keeps its zero value then
+// we find out by reading, ContentLength is -1 for a chunked body even when it is empty
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	return decodeJSON(w, r, v, true)
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any, emptyOK bool) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		//{"a":1}{"b":2} or {"a":1} junk, only one JSON value is allowed
+		if dec.Decode(&struct{}{}) != io.EOF {
+			writeProblem(w, r, http.StatusBadRequest, "invalid JSON body: unexpected data after the JSON value")
+			return false
+		}
+		return true
+	}
+	if emptyOK && errors.Is(err, io.EOF) {
+		return true
+	}
+	writeProblem(w, r, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+	return false
+}
+
+func newID() string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type customerJSON struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+type orderJSON struct {
+	ID         string      `json:"id"`
+	CustomerID string      `json:"customerId"`
+	Amount     string      `json:"amount"`
+	Currency   string      `json:"currency"`
+	Status     OrderStatus `json:"status"`
+	CreatedAt  string      `json:"createdAt"`
+	Version    int         `json:"version"`
+}
+
+func toCustomerJSON(c customer) customerJSON {
+	return customerJSON{ID: c.id, Name: c.name, Phone: c.phone}
+}
+
+func toOrderJSON(o order) orderJSON {
+	return orderJSON{
+		ID:         o.id,
+		CustomerID: o.customerID,
+		Amount:     strings.TrimPrefix(o.amount.String(), o.amount.Currency()+" "),
+		Currency:   o.amount.Currency(),
+		Status:     o.status,
+		CreatedAt:  o.createdAt.UTC().Format(time.RFC3339),
+		Version:    o.version,
+	}
+}
+
+type page[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// limit and offset from query, fields says which of them are not valid numbers
+func pagination(r *http.Request) (limit int, offset int, fields []fieldError) {
+	limit, offset = defaultPageSize, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			fields = append(fields, fieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageSize)})
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fields = append(fields, fieldError{Field: "offset", Message: "must be 0 or more"})
+		}
+		offset = n
+	}
+	return limit, offset, fields
+}
+
+// every bad query parameter (paging or filter) is a 400 with the same validation problem
+func writeQueryProblem(w http.ResponseWriter, r *http.Request, fields []fieldError) {
+	writeProblem(w, r, http.StatusBadRequest, "invalid query", fields...)
+}
+
+func paginate[T any](items []T, limit int, offset int) page[T] {
+	p := page[T]{Items: []T{}, Total: len(items), Limit: limit, Offset: offset}
+	if offset < len(items) {
+		end := min(offset+limit, len(items))
+		p.Items = items[offset:end]
+	}
+	return p
+}
+
+func (a *orderAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "customers" && len(parts) == 1:
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodGet: a.listCustomers, http.MethodPost: a.createCustomer})
+	case parts[0] == "customers" && len(parts) == 2:
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { a.getCustomer(w, r, parts[1]) }})
+	case parts[0] == "orders" && len(parts) == 1:
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodGet: a.listOrders, http.MethodPost: a.createOrder})
+	case parts[0] == "orders" && len(parts) == 2:
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { a.getOrder(w, r, parts[1]) }})
+	case parts[0] == "orders" && len(parts) == 3 && parts[2] == "status":
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodPost: func(w http.ResponseWriter, r *http.Request) { a.updateStatus(w, r, parts[1]) }})
+	case parts[0] == "orders" && len(parts) == 3 && parts[2] == "events" && a.events != nil:
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { a.orderEvents(w, r, parts[1]) }})
+	case parts[0] == "orders" && len(parts) == 3 && parts[2] == "cancel":
+		a.route(w, r, map[string]http.HandlerFunc{http.MethodPost: func(w http.ResponseWriter, r *http.Request) { a.cancelOrder(w, r, parts[1]) }})
+	default:
+		writeProblem(w, r, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+func (a *orderAPI) route(w http.ResponseWriter, r *http.Request, methods map[string]http.HandlerFunc) {
+	handler, ok := methods[r.Method]
+	if !ok {
+		allowed := make([]string, 0, len(methods))
+		for m := range methods {
+			allowed = append(allowed, m)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeProblem(w, r, http.StatusMethodNotAllowed, r.Method+" not allowed")
+		return
+	}
+	handler(w, r)
+}
+
+func (a *orderAPI) createCustomer(w http.ResponseWriter, r *http.Request) {
+	var req customerJSON
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.ID == "" {
+		req.ID = newID()
+	}
+	valid, err := newCustomer(req.ID, req.Name, req.Phone, a.clock)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	c, err := a.customers.create(*valid)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toCustomerJSON(c))
+}
+
+func (a *orderAPI) getCustomer(w http.ResponseWriter, r *http.Request, id string) {
+	c, err := a.customers.get(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toCustomerJSON(c))
+}
+
+func (a *orderAPI) listCustomers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, fields := pagination(r)
+	if len(fields) > 0 {
+		writeQueryProblem(w, r, fields)
+		return
+	}
+	var items []customerJSON
+	for _, c := range a.customers.list() {
+		items = append(items, toCustomerJSON(c))
+	}
+	writeJSON(w, http.StatusOK, paginate(items, limit, offset))
+}
+
+type createOrderRequest struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customerId"`
+	Amount     string `json:"amount"` //string like "45.00", not a float
+	Currency   string `json:"currency"`
+}
+
+func (a *orderAPI) createOrder(w http.ResponseWriter, r *http.Request) {
+	var req createOrderRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = a.currency
+	}
+	if req.ID == "" {
+		req.ID = newID()
+	}
+	var v validationError
+	amount, err := money.Parse(req.Amount, req.Currency)
+	if err != nil {
+		v.add("amount", err.Error())
+	}
+	if req.CustomerID != "" {
+		if _, err := a.customers.get(req.CustomerID); err != nil {
+			v.add("customerId", "unknown customer")
+		}
+	}
+	valid, err := newOrder(req.ID, req.CustomerID, amount, a.clock)
+	v.merge(err)
+	if err := v.err(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	o, err := a.orders.create(*valid)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/orders/"+o.id)
+	writeJSON(w, http.StatusCreated, toOrderJSON(o))
+}
+
+func (a *orderAPI) getOrder(w http.ResponseWriter, r *http.Request, id string) {
+	o, err := a.orders.get(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toOrderJSON(o))
+}
+
+func (a *orderAPI) listOrders(w http.ResponseWriter, r *http.Request) {
+	limit, offset, fields := pagination(r)
+	filter := orderFilter{customerID: r.URL.Query().Get("customerId")}
+	if v := r.URL.Query().Get("status"); v != "" {
+		status, err := ParseOrderStatus(v)
+		if err != nil {
+			fields = append(fields, fieldError{Field: "status", Message: err.Error()})
+		}
+		filter.status = &status
+	}
+	if len(fields) > 0 {
+		writeQueryProblem(w, r, fields)
+		return
+	}
+	found, err := a.orders.list(filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	items := make([]orderJSON, 0, len(found))
+	for _, o := range found {
+		items = append(items, toOrderJSON(o))
+	}
+	writeJSON(w, http.StatusOK, paginate(items, limit, offset))
+}
+
+// status is a plain string so a wrong name is a 422 field error and not a 400 "invalid JSON"
+type statusRequest struct {
+	Status  string `json:"status"`
+	By      string `json:"by"`
+	Version int    `json:"version"` //0 means "whatever is current"
+}
+
+func (a *orderAPI) changeStatus(w http.ResponseWriter, r *http.Request, id string, to OrderStatus, req statusRequest) {
+	version := req.Version
+	if version == 0 {
+		current, err := a.orders.get(id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		version = current.version
+	}
+	if req.By == "" {
+		req.By = "api"
+	}
+	o, err := a.orders.updateStatus(id, to, req.By, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toOrderJSON(o))
+}
+
+func (a *orderAPI) updateStatus(w http.ResponseWriter, r *http.Request, id string) {
+	var req statusRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Status) == "" {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "status is required", fieldError{Field: "status", Message: "is required"})
+		return
+	}
+	to, err := ParseOrderStatus(req.Status)
+	if err != nil {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "status is not valid", fieldError{Field: "status", Message: err.Error()})
+		return
+	}
+	a.changeStatus(w, r, id, to, req)
+}
+
+// body is optional here, POST /orders/{id}/cancel with nothing cancels as "api"
+func (a *orderAPI) cancelOrder(w http.ResponseWriter, r *http.Request, id string) {
+	var req statusRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	if req.Status != "" {
+		writeProblem(w, r, http.StatusUnprocessableEntity, "cancel does not take a status", fieldError{Field: "status", Message: "must be empty"})
+		return
+	}
+	a.changeStatus(w, r, id, Cancelled, req)
+}
+
+func (a *orderAPI) orderEvents(w http.ResponseWriter, r *http.Request, id string) {
+	events := a.events.history(id)
+	if len(events) == 0 {
+		writeError(w, r, fmt.Errorf("%w: %s", errOrderNotFound, id))
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func apiDemo() {
+	fmt.Println("+++++REST API+++++")
+	server := httptest.NewServer(newServeAPI())
+	defer server.Close()
+
+	call := func(method string, path string, body string) {
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		defer resp.Body.Close()
+		reply, _ := io.ReadAll(resp.Body)
+		fmt.Println(method, path, resp.StatusCode, strings.TrimSpace(string(reply)))
+	}
+
+	call(http.MethodPost, "/customers", `{"id":"c1","name":"manish","phone":"+919876543210"}`)
+	call(http.MethodPost, "/customers", `{"name":"","phone":"12ab"}`) //validation errors
+	call(http.MethodPost, "/orders", `{"id":"o1","customerId":"c1","amount":"45.00"}`)
+	call(http.MethodPost, "/orders", `{"customerId":"nobody","amount":"-5"}`)           //all field errors together
+	call(http.MethodPost, "/orders/o1/status", `{"status":"Shipped","by":"warehouse"}`) //illegal jump
+	call(http.MethodPost, "/orders/o1/status", `{"status":"Confirmed","by":"warehouse","version":1}`)
+	call(http.MethodPost, "/orders/o1/cancel", `{"by":"manish"}`)
+	call(http.MethodGet, "/orders?status=cancelled&customerId=c1&limit=10", "")
+	call(http.MethodGet, "/orders/o1/events", "")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// brokenRepository fails list, to see the 500 path
+type brokenRepository struct {
+	*memoryOrderRepository
+}
+
+func (*brokenRepository) list(orderFilter) ([]order, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func newTestAPI(t *testing.T) *orderAPI {
+	t.Helper()
+	api := newServeAPI()
+	for _, call := range []struct{ path, body string }{
+		{"/customers", `{"id":"c1","name":"manish","phone":"+919876543210"}`},
+		{"/orders", `{"id":"o1","customerId":"c1","amount":"45.00"}`},
+	} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, call.path, strings.NewReader(call.body)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("setup %s = %d %s", call.path, rec.Code, rec.Body.String())
+		}
+	}
+	return api
+}
+
+func TestOrderAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		field  string //field error expected in the problem body
+	}{
+		{"create customer", http.MethodPost, "/customers", `{"id":"c2","name":"ajay","phone":"9876543210"}`, http.StatusCreated, ""},
+		{"create customer invalid", http.MethodPost, "/customers", `{"name":"","phone":"12ab"}`, http.StatusUnprocessableEntity, "phone"},
+		{"create customer twice", http.MethodPost, "/customers", `{"id":"c1","name":"manish","phone":"+919876543210"}`, http.StatusConflict, ""},
+		{"create customer bad json", http.MethodPost, "/customers", `{"id":`, http.StatusBadRequest, ""},
+		{"create customer unknown field", http.MethodPost, "/customers", `{"id":"c3","nmae":"x"}`, http.StatusBadRequest, ""},
+		{"list customers", http.MethodGet, "/customers", "", http.StatusOK, ""},
+		{"list customers bad limit", http.MethodGet, "/customers?limit=0", "", http.StatusBadRequest, "limit"},
+		{"get customer", http.MethodGet, "/customers/c1", "", http.StatusOK, ""},
+		{"get customer missing", http.MethodGet, "/customers/nobody", "", http.StatusNotFound, ""},
+		{"customers wrong method", http.MethodDelete, "/customers", "", http.StatusMethodNotAllowed, ""},
+
+		{"create order", http.MethodPost, "/orders", `{"id":"o2","customerId":"c1","amount":"10.50"}`, http.StatusCreated, ""},
+		{"create order invalid", http.MethodPost, "/orders", `{"customerId":"nobody","amount":"-5"}`, http.StatusUnprocessableEntity, "customerId"},
+		{"create order twice", http.MethodPost, "/orders", `{"id":"o1","customerId":"c1","amount":"45.00"}`, http.StatusConflict, ""},
+		{"list orders", http.MethodGet, "/orders?status=received&customerId=c1", "", http.StatusOK, ""},
+		{"list orders bad status", http.MethodGet, "/orders?status=lost", "", http.StatusBadRequest, "status"},
+		{"list orders bad offset", http.MethodGet, "/orders?offset=-1", "", http.StatusBadRequest, "offset"},
+		{"list orders bad status and limit", http.MethodGet, "/orders?status=lost&limit=0", "", http.StatusBadRequest, "limit"},
+		{"create order trailing json", http.MethodPost, "/orders", `{"id":"o3","customerId":"c1","amount":"1.00"}{"id":"o4"}`, http.StatusBadRequest, ""},
+		{"create order trailing junk", http.MethodPost, "/orders", `{"id":"o3","customerId":"c1","amount":"1.00"} junk`, http.StatusBadRequest, ""},
+		{"get order", http.MethodGet, "/orders/o1", "", http.StatusOK, ""},
+		{"get order missing", http.MethodGet, "/orders/nope", "", http.StatusNotFound, ""},
+		{"order wrong method", http.MethodPut, "/orders/o1", "", http.StatusMethodNotAllowed, ""},
+
+		{"update status", http.MethodPost, "/orders/o1/status", `{"status":"Confirmed","by":"warehouse","version":1}`, http.StatusOK, ""},
+		{"update status illegal jump", http.MethodPost, "/orders/o1/status", `{"status":"Shipped"}`, http.StatusConflict, ""},
+		{"update status old version", http.MethodPost, "/orders/o1/status", `{"status":"Confirmed","version":7}`, http.StatusConflict, ""},
+		{"update status unknown name", http.MethodPost, "/orders/o1/status", `{"status":"Teleported"}`, http.StatusUnprocessableEntity, "status"},
+		{"update status missing", http.MethodPost, "/orders/o1/status", `{"by":"warehouse"}`, http.StatusUnprocessableEntity, "status"},
+		{"update status unknown order", http.MethodPost, "/orders/nope/status", `{"status":"Confirmed"}`, http.StatusNotFound, ""},
+		{"status wrong method", http.MethodGet, "/orders/o1/status", "", http.StatusMethodNotAllowed, ""},
+
+		{"cancel", http.MethodPost, "/orders/o1/cancel", `{"by":"manish"}`, http.StatusOK, ""},
+		{"cancel empty body", http.MethodPost, "/orders/o1/cancel", "", http.StatusOK, ""},
+		{"cancel bad json", http.MethodPost, "/orders/o1/cancel", `{"by":`, http.StatusBadRequest, ""},
+		{"cancel trailing json", http.MethodPost, "/orders/o1/cancel", `{"by":"manish"}{"by":"manish"}`, http.StatusBadRequest, ""},
+		{"cancel trailing spaces", http.MethodPost, "/orders/o1/cancel", "{\"by\":\"manish\"}\n  ", http.StatusOK, ""},
+		{"events", http.MethodGet, "/orders/o1/events", "", http.StatusOK, ""},
+		{"events unknown order", http.MethodGet, "/orders/nope/events", "", http.StatusNotFound, ""},
+		{"cancel with status", http.MethodPost, "/orders/o1/cancel", `{"status":"Shipped"}`, http.StatusUnprocessableEntity, "status"},
+
+		{"unknown endpoint", http.MethodGet, "/invoices", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, body))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if rec.Code < 400 {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", ct)
+			}
+			var p problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatalf("problem body: %v", err)
+			}
+			if p.Status != tt.want {
+				t.Errorf("problem status = %d, want %d", p.Status, tt.want)
+			}
+			if tt.field != "" && !hasFieldError(p, tt.field) {
+				t.Errorf("no field error for %q in %+v", tt.field, p.Errors)
+			}
+		})
+	}
+}
+
+// paging and filter errors are both bad query parameters, clients must see one shape for them
+func TestQueryProblemsMatch(t *testing.T) {
+	api := newTestAPI(t)
+	for _, path := range []string{"/orders?limit=0", "/orders?offset=x", "/orders?status=lost", "/customers?limit=500"} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var p problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if rec.Code != http.StatusBadRequest || p.Type != "/problems/validation" || p.Detail != "invalid query" {
+			t.Errorf("%s = %d %q %q, want 400 /problems/validation \"invalid query\"", path, rec.Code, p.Type, p.Detail)
+		}
+	}
+}
+
+func hasFieldError(p problem, field string) bool {
+	for _, f := range p.Errors {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// a chunked request has ContentLength -1, an empty one must still cancel
+func TestCancelWithEmptyChunkedBody(t *testing.T) {
+	server := httptest.NewServer(newTestAPI(t))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/orders/o1/cancel", io.MultiReader()) //unknown length -> chunked
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reply, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want 200: %s", resp.StatusCode, reply)
+	}
+	var o orderJSON
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != Cancelled {
+		t.Errorf("order status = %v, want Cancelled", o.Status)
+	}
+}
+
+func TestInternalErrorIsProblem(t *testing.T) {
+	api := newOrderAPI(&brokenRepository{newMemoryOrderRepository(orderRepositoryOptions{})}, newCustomerStore(), nil)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "disk on fire") {
+		t.Error("internal error detail leaked to the client")
+	}
+}
+
+func TestAPIAddrDefaultsToLocalhost(t *testing.T) {
+	t.Setenv("ORDER_API_ADDR", "")
+	if got := apiAddr(); got != "localhost:8080" {
+		t.Errorf("apiAddr() = %q, want localhost:8080", got)
+	}
+	t.Setenv("ORDER_API_ADDR", ":9000")
+	if got := apiAddr(); got != ":9000" {
+		t.Errorf("apiAddr() = %q, want :9000", got)
+	}
+}
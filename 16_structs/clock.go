@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+code calling time.Now() directly can not be tested, test would need to wait for real time
+so time comes from a Clock interface (dependency injection again, like paymenter)
+- realClock -> just calls the time package
+- fakeClock -> time stands still until test calls Advance, Sleep/After/tickers fire when fake time reaches them
+orders, the repository, the event log and the student registry ask for Now, waiting code gets Sleep, After and NewTicker
+*/
+
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker we use, as interface so fake clock can give its own
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	period  time.Duration
+	next    time.Time
+	ch      chan time.Time
+	stopped bool
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1) //buffered so Advance never blocks
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, &fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Sleep blocks until some other goroutine moves the clock forward enough
+func (c *fakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker") //same as time.NewTicker
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, period: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
+
+// Advance moves fake time and fires every timer and ticker which is due, negative d moves it back (like NTP fixing the system clock)
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- t.at
+	}
+	c.timers = pending
+
+	live := c.tickers[:0]
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue //stopped ticker never fires again, forget it
+		}
+		for !t.next.After(c.now) {
+			select {
+			case t.ch <- t.next:
+			default: //reader is slow, drop the tick like time.Ticker does
+			}
+			t.next = t.next.Add(t.period)
+		}
+		live = append(live, t)
+	}
+	c.tickers = live
+}
@@ -1,24 +1,152 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
+
+	"learngo/internal/money"
 )
 
 type students struct { //making struct of order syntax is: type struct_name struct
-	id        string
-	name      string
-	class     string
-	createdAt time.Time //nanosecond
+	id          string
+	name        string
+	class       string
+	createdAt   time.Time //nanosecond
+	withdrawnAt time.Time //zero while student is still enrolled
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+// people type phones as " +91 98765-43210", separators are dropped so the same number is stored one way
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+var knownClasses = map[string]bool{
+	"1st": true, "2nd": true, "3rd": true, "4th": true, "5th": true, "6th": true,
+	"7th": true, "8th": true, "9th": true, "10th": true, "11th": true, "12th": true,
+}
+
+/*
+validationError collects all wrong fields at once
+so user sees "id is required, phone is not valid" together and not one by one after every submit
+*/
+type validationError struct {
+	fields []fieldError
+}
+
+func (e *validationError) add(field string, message string) {
+	e.fields = append(e.fields, fieldError{Field: field, Message: message})
+}
+
+// nil when nothing was added, so constructors can just return v.err()
+func (e *validationError) err() error {
+	if len(e.fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// merge adds fields of another validationError, a field already reported is not added again
+func (e *validationError) merge(err error) {
+	var other *validationError
+	if !errors.As(err, &other) {
+		return
+	}
+	for _, f := range other.fields {
+		if !e.has(f.Field) {
+			e.fields = append(e.fields, f)
+		}
+	}
+}
+
+func (e *validationError) has(field string) bool {
+	for _, f := range e.fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *validationError) Error() string {
+	parts := make([]string, 0, len(e.fields))
+	for _, f := range e.fields {
+		parts = append(parts, f.Field+" "+f.Message)
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+// required gives back the trimmed value, so " 42" is stored as "42" and found again by get("42")
+func required(v *validationError, field string, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		v.add(field, "is required")
+	}
+	return value
+}
+
+// normalizePhone trims the phone and drops separators, the result is what gets validated and stored
+func normalizePhone(phone string) string {
+	return phoneSeparators.Replace(strings.TrimSpace(phone))
+}
+
+// clockNow is the time from clock, nil clock -> real time
+func clockNow(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
 }
 
 // making constructor -> follow abstraction, hidding the making process, initial setup goes here
-func newStudent(id string, name string, class string) *students { //this function will return the pointer to the object of struct
+// createdAt comes from clock (nil -> real time), the registry passes its own clock so tests control it
+func newStudent(id string, name string, class string, clock Clock) (*students, error) { //this function will return the pointer to the object of struct
+	var v validationError
+	id = required(&v, "id", id)
+	name = required(&v, "name", name)
+	if !knownClasses[class] {
+		v.add("class", fmt.Sprintf("%q is not a known class", class))
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	myStudent := students{ //making instance of the struct
-		id:    id,
-		name:  name,
-		class: class,
+		id:        id,
+		name:      name,
+		class:     class,
+		createdAt: clockNow(clock),
 	}
 	fmt.Println(&myStudent, "my student address from constructor")
-	return &myStudent
+	return &myStudent, nil
+}
+
+func newCustomer(id string, name string, phone string, clock Clock) (*customer, error) {
+	var v validationError
+	id = required(&v, "id", id)
+	name = required(&v, "name", name)
+	phone = normalizePhone(phone)
+	if !phonePattern.MatchString(phone) {
+		v.add("phone", "must be 7 to 15 digits, optional leading +")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return &customer{id: id, name: name, phone: phone, createdAt: clockNow(clock)}, nil
+}
+
+// every new order starts as Recieved, createdAt comes from clock (nil -> real time)
+func newOrder(id string, customerID string, amount money.Money, clock Clock) (*order, error) {
+	var v validationError
+	id = required(&v, "id", id)
+	customerID = required(&v, "customerId", customerID)
+	if !amount.IsPositive() {
+		v.add("amount", "must be more than zero")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return &order{id: id, customerID: customerID, amount: amount, status: Recieved, createdAt: clockNow(clock)}, nil
 }
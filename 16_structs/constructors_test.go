@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+func TestConstructorsTrimIDs(t *testing.T) {
+	s, err := newStudent(" 7 ", " manish ", "10th", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.id != "7" || s.name != "manish" {
+		t.Errorf("student = %q %q, want trimmed id and name", s.id, s.name)
+	}
+
+	c, err := newCustomer(" c1\t", "ajay", "9876543210", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.id != "c1" {
+		t.Errorf("customer id = %q, want c1", c.id)
+	}
+
+	o, err := newOrder(" o1 ", " c1 ", money.MustParse("1.00", "INR"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.id != "o1" || o.customerID != "c1" {
+		t.Errorf("order = %q %q, want trimmed ids", o.id, o.customerID)
+	}
+}
+
+func TestConstructorsReportAllFields(t *testing.T) {
+	_, err := newOrder("  ", "", money.MustParse("0.00", "INR"), nil)
+	var v *validationError
+	if !errors.As(err, &v) {
+		t.Fatalf("err = %v, want validationError", err)
+	}
+	for _, field := range []string{"id", "customerId", "amount"} {
+		if !v.has(field) {
+			t.Errorf("no error for %s in %v", field, err)
+		}
+	}
+}
+
+func TestNewCustomerNormalizesPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+		ok    bool
+	}{
+		{"9876543210", "9876543210", true},
+		{"  9876543210 ", "9876543210", true},
+		{"+91 98765 43210", "+919876543210", true},
+		{"+91-98765-43210", "+919876543210", true},
+		{"(022) 2345.6789", "02223456789", true},
+		{"98765abcde", "", false},
+		{"++919876543210", "", false},
+		{"98 76", "", false},
+		{"   ", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			c, err := newCustomer("c1", "ajay", tt.phone, nil)
+			if !tt.ok {
+				var v *validationError
+				if !errors.As(err, &v) || !v.has("phone") {
+					t.Errorf("err = %v, want a phone field error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.phone != tt.want {
+				t.Errorf("phone = %q, want %q", c.phone, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstructorsStampCreatedAt(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	fake := newFakeClock(start)
+
+	s, err := newStudent("7", "manish", "10th", fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Minute)
+	c, err := newCustomer("c1", "ajay", "9876543210", fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Minute)
+	o, err := newOrder("o1", "c1", money.MustParse("1.00", "INR"), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.createdAt.Equal(start) || !c.createdAt.Equal(start.Add(time.Minute)) || !o.createdAt.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("createdAt = %v %v %v, want the fake clock time at each call", s.createdAt, c.createdAt, o.createdAt)
+	}
+
+	//repository keeps the time from the constructor
+	created, err := newMemoryOrderRepository(orderRepositoryOptions{clock: newFakeClock(start.Add(time.Hour))}).create(*o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.createdAt.Equal(o.createdAt) {
+		t.Errorf("stored createdAt = %v, want %v", created.createdAt, o.createdAt)
+	}
+
+	if o, _ := newOrder("o2", "c1", money.MustParse("1.00", "INR"), nil); o.createdAt.IsZero() {
+		t.Error("nil clock left createdAt zero, want real time")
+	}
+}
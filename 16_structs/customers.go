@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// same customer as 17_struct_embedding, here orders point to it by id instead of embedding a copy
+type customer struct {
+	id        string
+	name      string
+	phone     string
+	createdAt time.Time
+}
+
+var (
+	errCustomerNotFound = errors.New("customer not found")
+	errCustomerExists   = errors.New("customer already exists")
+)
+
+type customerStore struct {
+	mu        sync.Mutex
+	customers map[string]customer
+}
+
+func newCustomerStore() *customerStore {
+	return &customerStore{customers: map[string]customer{}}
+}
+
+func (s *customerStore) create(c customer) (customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.customers[c.id]; exists {
+		return customer{}, fmt.Errorf("%w: %s", errCustomerExists, c.id)
+	}
+	s.customers[c.id] = c
+	return c, nil
+}
+
+func (s *customerStore) get(id string) (customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.customers[id]
+	if !ok {
+		return customer{}, fmt.Errorf("%w: %s", errCustomerNotFound, id)
+	}
+	return c, nil
+}
+
+func (s *customerStore) list() []customer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]customer, 0, len(s.customers))
+	for _, c := range s.customers {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
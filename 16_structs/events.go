@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"learngo/internal/jsonl"
+	"learngo/internal/money"
+)
+
+/*
+changeStatus overwrites the status, the old value is gone (history only keeps transitions, not amount/customer changes)
+event sourcing -> we never overwrite, we only append what happened (events) to a log
+current state = start from empty order and apply all events one by one (replay)
+state at any time in the past = replay only the events till that time
+replaying thousands of events is slow so every snapshotEvery events we keep a copy of the order (snapshot)
+and replay starts from the nearest snapshot instead of from the beginning
+the log stamps the time of every event itself, so events are always in time order and stateAt can stop at the first later one
+the repository writes to the log on create and on every status change, so REST calls end up here too
+*/
+
+type orderEventType string
+
+const (
+	orderCreated    orderEventType = "OrderCreated"
+	statusChanged   orderEventType = "StatusChanged"
+	paymentCaptured orderEventType = "PaymentCaptured"
+	customerUpdated orderEventType = "CustomerUpdated"
+)
+
+var errEventNotApplicable = errors.New("event can not be applied")
+
+// one struct for all events, only the fields of that event type are filled (exported for the JSON file)
+type orderEvent struct {
+	Seq     int64          `json:"seq"`
+	OrderID string         `json:"orderId"`
+	Type    orderEventType `json:"type"`
+	At      time.Time      `json:"at"`
+	By      string         `json:"by"`
+
+	CustomerID    string      `json:"customerId,omitempty"`
+	AmountMinor   int64       `json:"amountMinor,omitempty"`
+	Currency      string      `json:"currency,omitempty"`
+	Status        OrderStatus `json:"status"` //no omitempty, Recieved is 0 and would be dropped
+	TransactionID string      `json:"transactionId,omitempty"`
+}
+
+func (e orderEvent) money() money.Money {
+	return money.FromMinor(e.AmountMinor, e.Currency)
+}
+
+// apply moves the order one event forward, same rules as the normal code so a bad event can not get in
+func (o *order) apply(e orderEvent) error {
+	if e.Type != orderCreated && o.id == "" {
+		return fmt.Errorf("%w: %s before OrderCreated", errEventNotApplicable, e.Type)
+	}
+	switch e.Type {
+	case orderCreated:
+		if o.id != "" {
+			return fmt.Errorf("%w: order %s already created", errEventNotApplicable, o.id)
+		}
+		*o = order{id: e.OrderID, customerID: e.CustomerID, amount: e.money(), status: Recieved, createdAt: e.At}
+	case statusChanged:
+		if err := canTransition(o.status, e.Status); err != nil {
+			return err
+		}
+		o.history = append(o.history, transition{from: o.status, to: e.Status, by: e.By, at: e.At})
+		o.status = e.Status
+	case paymentCaptured:
+		if err := o.amount.SameCurrency(e.money()); err != nil {
+			return err
+		}
+		o.paymentID = e.TransactionID
+		o.paid = e.money()
+	case customerUpdated:
+		o.customerID = e.CustomerID
+	default:
+		return fmt.Errorf("%w: unknown event type %q", errEventNotApplicable, e.Type)
+	}
+	o.version++
+	return nil
+}
+
+type orderSnapshot struct {
+	seq   int64     //last event inside the snapshot
+	at    time.Time //time of that event
+	order order
+}
+
+type orderEventLog struct {
+	snapshotEvery int
+	clock         Clock
+
+	mu        sync.Mutex
+	nextSeq   int64
+	events    map[string][]orderEvent    //per order, in append order
+	snapshots map[string][]orderSnapshot //per order, oldest first
+	lastAt    time.Time                  //time of the newest event, the clock can go back (NTP) but the log can not
+	file      *os.File                   //nil for memory only log
+}
+
+// clock stamps the events, nil -> real time
+func newOrderEventLog(snapshotEvery int, clock Clock) *orderEventLog {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &orderEventLog{
+		snapshotEvery: snapshotEvery,
+		clock:         clock,
+		nextSeq:       1,
+		events:        map[string][]orderEvent{},
+		snapshots:     map[string][]orderSnapshot{},
+	}
+}
+
+// file backed log, one event per line, snapshots are rebuilt while loading
+func openOrderEventLog(path string, snapshotEvery int, clock Clock) (*orderEventLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening event log: %w", err)
+	}
+	l := newOrderEventLog(snapshotEvery, clock)
+	//a torn last line (crash while writing) is cut off by jsonl.Replay, that event was never confirmed to anyone
+	err = jsonl.Replay(f, func(line []byte) error {
+		var e orderEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		state, err := l.next(e)
+		if err != nil {
+			return err
+		}
+		l.keep(e, state)
+		l.nextSeq = e.Seq + 1
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading event log %s: %w", path, err)
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *orderEventLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// append checks the event against the current state, writes it and returns it with seq and time filled
+// At is always taken from the log clock, a caller sent time could put the event before older ones
+func (l *orderEventLog) append(e orderEvent) (orderEvent, error) {
+	return l.appendAfter(e, nil)
+}
+
+/*
+appendAfter is append with a save step between the check and the write (used by the repository)
+save gets the event with seq and time filled, if it fails the event is dropped,
+so the log never has an event for a change that was not stored
+*/
+func (l *orderEventLog) appendAfter(e orderEvent, save func(e orderEvent) error) (orderEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = l.nextSeq
+	e.At = l.clock.Now()
+	if e.At.Before(l.lastAt) {
+		e.At = l.lastAt
+	}
+	state, err := l.next(e) //validate first so a bad event never reaches the file
+	if err != nil {
+		return orderEvent{}, err
+	}
+	if save != nil {
+		if err := save(e); err != nil {
+			return orderEvent{}, err
+		}
+	}
+	if l.file != nil {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return orderEvent{}, err
+		}
+		if err := jsonl.Append(l.file, line); err != nil {
+			return orderEvent{}, fmt.Errorf("writing event log: %w", err)
+		}
+	}
+	l.keep(e, state)
+	l.nextSeq++
+	return e, nil
+}
+
+// next returns the order state after e, caller holds the lock
+func (l *orderEventLog) next(e orderEvent) (order, error) {
+	current, err := l.replay(e.OrderID, time.Time{})
+	if err != nil && !errors.Is(err, errOrderNotFound) {
+		return order{}, err
+	}
+	if err := current.apply(e); err != nil { //for a new order current is empty and only OrderCreated applies
+		return order{}, err
+	}
+	return current, nil
+}
+
+// keep stores the event and takes a snapshot when it is time, caller holds the lock
+func (l *orderEventLog) keep(e orderEvent, state order) {
+	if e.At.After(l.lastAt) {
+		l.lastAt = e.At
+	}
+	l.events[e.OrderID] = append(l.events[e.OrderID], e)
+	if l.snapshotEvery > 0 && len(l.events[e.OrderID])%l.snapshotEvery == 0 {
+		l.snapshots[e.OrderID] = append(l.snapshots[e.OrderID], orderSnapshot{seq: e.Seq, at: e.At, order: state.clone()})
+	}
+}
+
+// replay builds the order as it was at time at (zero time = now), starting from the nearest snapshot
+func (l *orderEventLog) replay(orderID string, at time.Time) (order, error) {
+	var o order
+	var afterSeq int64
+	snaps := l.snapshots[orderID]
+	//latest snapshot which is not after at
+	i := sort.Search(len(snaps), func(i int) bool { return !at.IsZero() && snaps[i].at.After(at) })
+	if i > 0 {
+		o = snaps[i-1].order.clone()
+		afterSeq = snaps[i-1].seq
+	}
+	for _, e := range l.events[orderID] {
+		if e.Seq <= afterSeq {
+			continue
+		}
+		if !at.IsZero() && e.At.After(at) {
+			break
+		}
+		if err := o.apply(e); err != nil {
+			return order{}, err
+		}
+	}
+	if o.id == "" {
+		return order{}, fmt.Errorf("%w: %s", errOrderNotFound, orderID)
+	}
+	return o, nil
+}
+
+func (l *orderEventLog) current(orderID string) (order, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.replay(orderID, time.Time{})
+}
+
+// stateAt -> how did the order look at that time
+func (l *orderEventLog) stateAt(orderID string, at time.Time) (order, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.replay(orderID, at)
+}
+
+func (l *orderEventLog) history(orderID string) []orderEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]orderEvent(nil), l.events[orderID]...)
+}
+
+// helpers so callers dont build events by hand
+
+func (l *orderEventLog) createOrder(id string, customerID string, amount money.Money, by string) (orderEvent, error) {
+	return l.append(orderEvent{OrderID: id, Type: orderCreated, By: by, CustomerID: customerID, AmountMinor: amount.Minor(), Currency: amount.Currency()})
+}
+
+func (l *orderEventLog) changeStatus(id string, status OrderStatus, by string) (orderEvent, error) {
+	return l.append(orderEvent{OrderID: id, Type: statusChanged, By: by, Status: status})
+}
+
+func (l *orderEventLog) capturePayment(id string, transactionID string, amount money.Money, by string) (orderEvent, error) {
+	return l.append(orderEvent{OrderID: id, Type: paymentCaptured, By: by, TransactionID: transactionID, AmountMinor: amount.Minor(), Currency: amount.Currency()})
+}
+
+func (l *orderEventLog) updateCustomer(id string, customerID string, by string) (orderEvent, error) {
+	return l.append(orderEvent{OrderID: id, Type: customerUpdated, By: by, CustomerID: customerID})
+}
+
+func eventsDemo() {
+	fmt.Println("+++++EVENT LOG+++++")
+	base := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	fake := newFakeClock(base)
+	log := newOrderEventLog(2, fake) //snapshot every 2 events to see it working
+
+	amount := money.MustParse("500.00", "INR")
+	steps := []func() (orderEvent, error){
+		func() (orderEvent, error) { return log.createOrder("201", "c1", amount, "manish") },
+		func() (orderEvent, error) { return log.capturePayment("201", "pay_123", amount, "razorpay") },
+		func() (orderEvent, error) { return log.changeStatus("201", Confrimed, "warehouse") },
+		func() (orderEvent, error) { return log.updateCustomer("201", "c2", "support") },
+		func() (orderEvent, error) { return log.changeStatus("201", Prepared, "warehouse") },
+	}
+	for _, step := range steps {
+		fake.Advance(time.Hour) //every event one hour later
+		step()
+	}
+
+	if _, err := log.changeStatus("201", Delivered, "courier"); err != nil { //illegal, never gets into the log
+		fmt.Println("error:", err)
+	}
+
+	for _, e := range log.history("201") {
+		fmt.Println(e.Seq, e.At.Format("15:04"), e.Type, e.By)
+	}
+	now, _ := log.current("201")
+	fmt.Println("now:", now.status, "customer", now.customerID, "paid", now.paid)
+
+	past, _ := log.stateAt("201", base.Add(150*time.Minute)) //between 2nd and 3rd event
+	fmt.Println("at 12:30:", past.status, "customer", past.customerID, "paid", past.paid)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+func TestEventLogStampsTimeItself(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	log := newOrderEventLog(0, fake)
+
+	log.createOrder("1", "c1", money.MustParse("10.00", "INR"), "manish")
+	fake.Advance(time.Hour)
+	e, err := log.append(orderEvent{OrderID: "1", Type: statusChanged, Status: Confrimed, At: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.At.Equal(fake.Now()) {
+		t.Errorf("event at %v, want the log clock %v", e.At, fake.Now())
+	}
+
+	fake.Advance(-2 * time.Hour) //clock jumped back
+	e, err = log.changeStatus("1", Prepared, "warehouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history := log.history("1"); e.At.Before(history[1].At) {
+		t.Errorf("event at %v is before the one already in the log at %v", e.At, history[1].At)
+	}
+}
+
+func TestEventLogSurvivesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := openOrderEventLog(path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.createOrder("1", "c1", money.MustParse("10.00", "INR"), "manish")
+	log.changeStatus("1", Confrimed, "warehouse")
+	log.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"orderId":"1","type":"StatusCh`)
+	f.Close()
+
+	reopened, err := openOrderEventLog(path, 0, nil)
+	if err != nil {
+		t.Fatalf("reopen with torn line: %v", err)
+	}
+	defer reopened.Close()
+	o, err := reopened.current("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.status != Confrimed {
+		t.Errorf("status = %v, want Confirmed", o.status)
+	}
+	if _, err := reopened.changeStatus("1", Prepared, "warehouse"); err != nil {
+		t.Fatalf("append after torn line: %v", err)
+	}
+}
+
+func TestRepositoryWritesEvents(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	log := newOrderEventLog(0, fake)
+	repo := newMemoryOrderRepository(orderRepositoryOptions{events: log, clock: fake})
+	created, err := repo.create(order{id: "1", customerID: "c1", amount: money.MustParse("10.00", "INR"), status: Recieved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := repo.updateStatus("1", Confrimed, "warehouse", created.version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.updateStatus("1", Delivered, "courier", updated.version); err == nil {
+		t.Fatal("illegal jump was accepted")
+	}
+
+	history := log.history("1")
+	if len(history) != 2 || history[0].Type != orderCreated || history[1].Type != statusChanged || history[1].By != "warehouse" {
+		t.Fatalf("events = %+v, want OrderCreated then StatusChanged by warehouse", history)
+	}
+	if !updated.history[0].at.Equal(history[1].At) {
+		t.Errorf("order history at %v, event at %v, want the same time", updated.history[0].at, history[1].At)
+	}
+}
+
+// a failed save must not leave an event for a change nobody stored
+func TestRepositoryFailedSaveWritesNoEvent(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	log := newOrderEventLog(0, fake)
+	repo := newMemoryOrderRepository(orderRepositoryOptions{events: log, clock: fake})
+	diskFull := errors.New("disk full")
+	created, err := repo.create(order{id: "1", customerID: "c1", amount: money.MustParse("10.00", "INR"), status: Recieved})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.persist = func(orderJournalEntry) error { return diskFull }
+	if _, err := repo.updateStatus("1", Confrimed, "warehouse", created.version); !errors.Is(err, diskFull) {
+		t.Fatalf("update err = %v, want the save error", err)
+	}
+	if _, err := repo.create(order{id: "2", customerID: "c1", amount: money.MustParse("5.00", "INR"), status: Recieved}); !errors.Is(err, diskFull) {
+		t.Fatalf("create err = %v, want the save error", err)
+	}
+	if history := log.history("1"); len(history) != 1 {
+		t.Errorf("order 1 events = %+v, want only OrderCreated", history)
+	}
+	if history := log.history("2"); len(history) != 0 {
+		t.Errorf("order 2 events = %+v, want none", history)
+	}
+
+	//store works again -> the same change goes through and the log continues where it was
+	repo.persist = nil
+	if o, _ := repo.get("1"); o.status != Recieved || o.version != 1 {
+		t.Fatalf("stored = %v version %d, want unchanged", o.status, o.version)
+	}
+	if _, err := repo.updateStatus("1", Confrimed, "warehouse", created.version); err != nil {
+		t.Fatal(err)
+	}
+	if history := log.history("1"); len(history) != 2 {
+		t.Errorf("events after retry = %+v, want OrderCreated and StatusChanged", history)
+	}
+}
+
+// event could not be written after the save -> save is undone so store and log still agree
+func TestRepositoryUndoesSaveWhenEventFails(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	log, err := openOrderEventLog(filepath.Join(t.TempDir(), "events.jsonl"), 0, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newMemoryOrderRepository(orderRepositoryOptions{events: log, clock: fake})
+	created, err := repo.create(order{id: "1", customerID: "c1", amount: money.MustParse("10.00", "INR"), status: Recieved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Close() //next event write fails
+
+	if _, err := repo.updateStatus("1", Confrimed, "warehouse", created.version); err == nil {
+		t.Fatal("update with a broken event log was accepted")
+	}
+	if o, _ := repo.get("1"); o.status != Recieved || o.version != 1 || len(o.history) != 0 {
+		t.Errorf("stored = %v version %d history %d, want the order before the update", o.status, o.version, len(o.history))
+	}
+	if _, err := repo.create(order{id: "2", customerID: "c1", amount: money.MustParse("5.00", "INR"), status: Recieved}); err == nil {
+		t.Fatal("create with a broken event log was accepted")
+	}
+	if _, err := repo.get("2"); !errors.Is(err, errOrderNotFound) {
+		t.Errorf("get 2 err = %v, want errOrderNotFound", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+OrderStatus goes into JSON (api, journal, events) by name not by number
+encoding/json calls MarshalText/UnmarshalText implicitly when the type has them, so no MarshalJSON is needed
+this is a trimmed copy of 19_enum/marshal.go, only the parts the order service uses
+*/
+
+// index of the slice is the value of the const, so keep it in the same order as the const block
+var orderStatusNames = []string{
+	"Received",
+	"Confirmed",
+	"Prepared",
+	"Shipped",
+	"Delivered",
+	"Cancelled",
+	"Returned",
+}
+
+type parseStatusError struct {
+	input string
+}
+
+func (e *parseStatusError) Error() string {
+	return fmt.Sprintf("unknown order status %q, expected one of %s", e.input, strings.Join(orderStatusNames, ", "))
+}
+
+func (s OrderStatus) String() string {
+	if !s.isValid() {
+		return fmt.Sprintf("OrderStatus(%d)", int(s))
+	}
+	return orderStatusNames[s]
+}
+
+// case insensitive, "shipped", "SHIPPED" and " Shipped " all give Shipped
+func ParseOrderStatus(name string) (OrderStatus, error) {
+	name = strings.TrimSpace(name)
+	for i, n := range orderStatusNames {
+		if strings.EqualFold(n, name) {
+			return OrderStatus(i), nil
+		}
+	}
+	return 0, &parseStatusError{input: name}
+}
+
+func (s OrderStatus) MarshalText() ([]byte, error) {
+	if !s.isValid() {
+		return nil, &unknownStatusError{status: s}
+	}
+	return []byte(s.String()), nil
+}
+
+// pointer reciever because we are writing into s
+func (s *OrderStatus) UnmarshalText(text []byte) error {
+	parsed, err := ParseOrderStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// same OrderStatus enum as 19_enum (trimmed), every lesson is its own main package so we declare it again here
+type OrderStatus int
+
+const (
+	Recieved OrderStatus = iota
+	Confrimed
+	Prepared
+	Shipped
+	Delivered
+	Cancelled
+	Returned
+)
+
+// allowed moves of an order, anything not in this table is an illegal jump (see 19_enum/lifecycle.go)
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	Recieved:  {Confrimed, Cancelled},
+	Confrimed: {Prepared, Cancelled},
+	Prepared:  {Shipped, Cancelled},
+	Shipped:   {Delivered},
+	Delivered: {Returned},
+}
+
+type transitionError struct {
+	from OrderStatus
+	to   OrderStatus
+}
+
+func (e *transitionError) Error() string {
+	return fmt.Sprintf("illegal order status transition from %v to %v", e.from, e.to)
+}
+
+type unknownStatusError struct {
+	status OrderStatus
+}
+
+func (e *unknownStatusError) Error() string {
+	return fmt.Sprintf("unknown order status %d", int(e.status))
+}
+
+func (s OrderStatus) isValid() bool {
+	return s >= Recieved && s <= Returned
+}
+
+func canTransition(from OrderStatus, to OrderStatus) error {
+	if !from.isValid() {
+		return &unknownStatusError{status: from}
+	}
+	if !to.isValid() {
+		return &unknownStatusError{status: to}
+	}
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &transitionError{from: from, to: to}
+}
+
+// audit trail entry -> who moved the order and when
+type transition struct {
+	from OrderStatus
+	to   OrderStatus
+	by   string
+	at   time.Time
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"learngo/internal/jsonl"
+	"learngo/internal/money"
+)
+
+/*
+order was living only inside main, after restart everything is gone
+OrderRepository is the contract for storing orders, main code only talks to the interface (same idea as paymenter in 18_interfaces)
+- memoryOrderRepository -> map in memory, good for tests
+- fileOrderRepository -> same map but every change is also appended as one JSON line to a file, on start we replay the file
+  a torn last line (crash in the middle of a write) is cut off, and the file is compacted to one line per order
+  when it has more lines than orders, so it does not grow forever with old versions
+
+optimistic concurrency: every order has a version, each save increments it
+update/delete must send the version they read, if someone else saved in between versions dont match -> errVersionConflict
+so two people updating the same order can not silently override each other (lost update), no lock is held while user is thinking
+
+with an event log the order is saved first and the event written after, a failed save leaves no event behind
+*/
+
+var (
+	errOrderNotFound   = errors.New("order not found")
+	errOrderExists     = errors.New("order already exists")
+	errVersionConflict = errors.New("order was modified by someone else")
+)
+
+type OrderRepository interface {
+	create(o order) (order, error)
+	get(id string) (order, error)
+	updateStatus(id string, status OrderStatus, by string, expectedVersion int) (order, error)
+	list(filter orderFilter) ([]order, error)
+	delete(id string, expectedVersion int) error
+}
+
+// zero value fields are not used for filtering
+type orderFilter struct {
+	status        *OrderStatus
+	customerID    string
+	createdAfter  time.Time
+	createdBefore time.Time
+}
+
+func (f orderFilter) matches(o order) bool {
+	if f.status != nil && o.status != *f.status {
+		return false
+	}
+	if f.customerID != "" && o.customerID != f.customerID {
+		return false
+	}
+	if !f.createdAfter.IsZero() && !o.createdAt.After(f.createdAfter) {
+		return false
+	}
+	if !f.createdBefore.IsZero() && !o.createdAt.Before(f.createdBefore) {
+		return false
+	}
+	return true
+}
+
+// copy of the order, history slice is copied too so caller can not change what is stored
+func (o order) clone() order {
+	o.history = append([]transition(nil), o.history...)
+	return o
+}
+
+// one line of the journal file
+type orderJournalEntry struct {
+	Op    string       `json:"op"` //put or delete
+	Order *orderRecord `json:"order,omitempty"`
+	ID    string       `json:"id,omitempty"`
+}
+
+// order has unexported fields, json package can not see them, so we copy into this for saving
+type orderRecord struct {
+	ID          string             `json:"id"`
+	CustomerID  string             `json:"customerId,omitempty"`
+	AmountMinor int64              `json:"amountMinor"`
+	Currency    string             `json:"currency"`
+	Status      OrderStatus        `json:"status"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Version     int                `json:"version"`
+	History     []transitionRecord `json:"history,omitempty"`
+}
+
+type transitionRecord struct {
+	From OrderStatus `json:"from"`
+	To   OrderStatus `json:"to"`
+	By   string      `json:"by"`
+	At   time.Time   `json:"at"`
+}
+
+func toOrderRecord(o order) *orderRecord {
+	r := &orderRecord{
+		ID:          o.id,
+		CustomerID:  o.customerID,
+		AmountMinor: o.amount.Minor(),
+		Currency:    o.amount.Currency(),
+		Status:      o.status,
+		CreatedAt:   o.createdAt,
+		Version:     o.version,
+	}
+	for _, t := range o.history {
+		r.History = append(r.History, transitionRecord{From: t.from, To: t.to, By: t.by, At: t.at})
+	}
+	return r
+}
+
+func (r orderRecord) toOrder() order {
+	o := order{
+		id:         r.ID,
+		customerID: r.CustomerID,
+		amount:     money.FromMinor(r.AmountMinor, r.Currency),
+		status:     r.Status,
+		createdAt:  r.CreatedAt,
+		version:    r.Version,
+	}
+	for _, t := range r.History {
+		o.history = append(o.history, transition{from: t.From, to: t.To, by: t.By, at: t.At})
+	}
+	return o
+}
+
+type orderRepositoryOptions struct {
+	events *orderEventLog //nil -> changes are not written to an event log
+	clock  Clock          //createdAt and status history time, nil -> real time
+}
+
+type memoryOrderRepository struct {
+	opts orderRepositoryOptions
+
+	mu     sync.Mutex
+	orders map[string]order
+	//called under the lock before a change is applied, if it fails the change is dropped (used by the file repo)
+	persist func(orderJournalEntry) error
+}
+
+func newMemoryOrderRepository(opts orderRepositoryOptions) *memoryOrderRepository {
+	if opts.clock == nil {
+		opts.clock = realClock{}
+	}
+	return &memoryOrderRepository{opts: opts, orders: map[string]order{}}
+}
+
+func (r *memoryOrderRepository) save(o order) error {
+	if r.persist != nil {
+		if err := r.persist(orderJournalEntry{Op: "put", Order: toOrderRecord(o)}); err != nil {
+			return err
+		}
+	}
+	r.orders[o.id] = o.clone()
+	return nil
+}
+
+func (r *memoryOrderRepository) create(o order) (order, error) {
+	if o.id == "" {
+		return order{}, errors.New("order id is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.orders[o.id]; exists {
+		return order{}, fmt.Errorf("%w: %s", errOrderExists, o.id)
+	}
+	if o.createdAt.IsZero() {
+		o.createdAt = r.opts.clock.Now()
+	}
+	o.version = 1
+	created := orderEvent{OrderID: o.id, Type: orderCreated, By: "repository", CustomerID: o.customerID, AmountMinor: o.amount.Minor(), Currency: o.amount.Currency()}
+	if err := r.saveWithEvent(&o, nil, created, nil); err != nil {
+		return order{}, err
+	}
+	return o.clone(), nil
+}
+
+/*
+saveWithEvent stores o and writes e to the event log (if there is one), save goes first
+and the event is written only when it worked, if writing the event fails the save is undone
+so the store and the log agree, previous is the order before the change (nil for a new one)
+stamp changes o with the event time before the save, so the order history has the same time as the log
+caller holds the lock
+*/
+func (r *memoryOrderRepository) saveWithEvent(o *order, previous *order, e orderEvent, stamp func(o *order, at time.Time)) error {
+	if r.opts.events == nil {
+		if stamp != nil {
+			stamp(o, r.opts.clock.Now())
+		}
+		return r.save(*o)
+	}
+	saved := false
+	_, err := r.opts.events.appendAfter(e, func(e orderEvent) error {
+		if stamp != nil {
+			stamp(o, e.At)
+		}
+		if err := r.save(*o); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if err != nil && saved {
+		if undoErr := r.undo(o.id, previous); undoErr != nil {
+			return errors.Join(err, fmt.Errorf("undoing save of %s: %w", o.id, undoErr))
+		}
+	}
+	return err
+}
+
+// undo puts back the order as it was before a save, caller holds the lock
+func (r *memoryOrderRepository) undo(id string, previous *order) error {
+	if previous != nil {
+		return r.save(*previous)
+	}
+	if r.persist != nil {
+		if err := r.persist(orderJournalEntry{Op: "delete", ID: id}); err != nil {
+			return err
+		}
+	}
+	delete(r.orders, id)
+	return nil
+}
+
+func (r *memoryOrderRepository) get(id string) (order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.orders[id]
+	if !ok {
+		return order{}, fmt.Errorf("%w: %s", errOrderNotFound, id)
+	}
+	return o.clone(), nil
+}
+
+// finds the order and checks the version, caller holds the lock
+func (r *memoryOrderRepository) current(id string, expectedVersion int) (order, error) {
+	o, ok := r.orders[id]
+	if !ok {
+		return order{}, fmt.Errorf("%w: %s", errOrderNotFound, id)
+	}
+	if o.version != expectedVersion {
+		return order{}, fmt.Errorf("%w: %s is at version %d, not %d", errVersionConflict, id, o.version, expectedVersion)
+	}
+	return o.clone(), nil
+}
+
+func (r *memoryOrderRepository) updateStatus(id string, status OrderStatus, by string, expectedVersion int) (order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, err := r.current(id, expectedVersion)
+	if err != nil {
+		return order{}, err
+	}
+	if err := canTransition(o.status, status); err != nil { //lifecycle rules still apply
+		return order{}, err
+	}
+	previous := o.clone()
+	o.version++
+	changed := orderEvent{OrderID: id, Type: statusChanged, By: by, Status: status}
+	stamp := func(o *order, at time.Time) { o.setStatus(status, by, at) }
+	if err := r.saveWithEvent(&o, &previous, changed, stamp); err != nil {
+		return order{}, err
+	}
+	return o.clone(), nil
+}
+
+// sorted by creation time so the result is same every time (map order is random)
+func (r *memoryOrderRepository) list(filter orderFilter) ([]order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []order
+	for _, o := range r.orders {
+		if filter.matches(o) {
+			out = append(out, o.clone())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].createdAt.Equal(out[j].createdAt) {
+			return out[i].createdAt.Before(out[j].createdAt)
+		}
+		return out[i].id < out[j].id
+	})
+	return out, nil
+}
+
+func (r *memoryOrderRepository) delete(id string, expectedVersion int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.current(id, expectedVersion); err != nil {
+		return err
+	}
+	if r.persist != nil {
+		if err := r.persist(orderJournalEntry{Op: "delete", ID: id}); err != nil {
+			return err
+		}
+	}
+	delete(r.orders, id)
+	return nil
+}
+
+// file backed repository, JSON lines journal + the memory map for reads
+type fileOrderRepository struct {
+	*memoryOrderRepository
+	path string
+	file *os.File
+}
+
+func openFileOrderRepository(path string, opts orderRepositoryOptions) (*fileOrderRepository, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening order store: %w", err)
+	}
+	mem := newMemoryOrderRepository(opts)
+
+	//replay -> last line for an id wins
+	lines := 0
+	err = jsonl.Replay(f, func(line []byte) error {
+		var entry orderJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		lines++
+		switch {
+		case entry.Op == "put" && entry.Order != nil:
+			mem.orders[entry.Order.ID] = entry.Order.toOrder()
+		case entry.Op == "delete":
+			delete(mem.orders, entry.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading order store %s: %w", path, err)
+	}
+
+	repo := &fileOrderRepository{memoryOrderRepository: mem, path: path, file: f}
+	mem.persist = repo.append
+	if lines > len(mem.orders) { //old versions or deleted orders in the file
+		if err := repo.compact(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+func (r *fileOrderRepository) append(entry orderJournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := jsonl.Append(r.file, line); err != nil {
+		return fmt.Errorf("writing order store: %w", err)
+	}
+	return r.file.Sync() //make sure it is on disk before we say ok
+}
+
+// compact rewrites the journal with one put line per live order, writes wait on the lock till it is done
+func (r *fileOrderRepository) compact() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.orders))
+	for id := range r.orders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	err := jsonl.Compact(r.path, func(w io.Writer) error {
+		for _, id := range ids {
+			line, err := json.Marshal(orderJournalEntry{Op: "put", Order: toOrderRecord(r.orders[id])})
+			if err != nil {
+				return err
+			}
+			if err := jsonl.Append(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compacting order store: %w", err)
+	}
+	f, err := os.OpenFile(r.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopening order store: %w", err)
+	}
+	r.file.Close()
+	r.file = f
+	return nil
+}
+
+func (r *fileOrderRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func repositoryDemo() {
+	fmt.Println("+++++ORDER REPOSITORY+++++")
+	tmp, err := os.CreateTemp("", "orders-*.jsonl") //temp file, running the demo leaves nothing in the lesson folder
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	tmp.Close()
+	path := tmp.Name()
+	defer os.Remove(path)
+	repo, err := openFileOrderRepository(path, orderRepositoryOptions{})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer repo.Close()
+
+	var store OrderRepository = repo
+	created, _ := store.create(order{id: "101", amount: money.MustParse("250.00", "INR"), status: Recieved})
+	updated, _ := store.updateStatus("101", Confrimed, "manish", created.version)
+	fmt.Println("order", updated.id, updated.status, "version", updated.version)
+
+	//second update with the old version is rejected
+	if _, err := store.updateStatus("101", Cancelled, "ajay", created.version); errors.Is(err, errVersionConflict) {
+		fmt.Println("error:", err)
+	}
+
+	//open the same file again like after a restart, the create and update lines are compacted into one
+	reopened, err := openFileOrderRepository(path, orderRepositoryOptions{})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer reopened.Close()
+	confirmed := Confrimed
+	found, _ := reopened.list(orderFilter{status: &confirmed})
+	for _, o := range found {
+		fmt.Println("after restart:", o.id, o.amount, o.status, "version", o.version)
+	}
+}
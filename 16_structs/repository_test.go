@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+func TestFileOrderRepositoryCompactsAndSurvivesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+	repo, err := openFileOrderRepository(path, orderRepositoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := repo.create(order{id: "101", amount: money.MustParse("250.00", "INR"), status: Recieved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.updateStatus("101", Confrimed, "manish", created.version); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	//crash in the middle of the next write
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","order":{"id":"102"`)
+	f.Close()
+
+	reopened, err := openFileOrderRepository(path, orderRepositoryOptions{})
+	if err != nil {
+		t.Fatalf("reopen with torn line: %v", err)
+	}
+	defer reopened.Close()
+	o, err := reopened.get("101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.status != Confrimed || o.version != 2 {
+		t.Errorf("after reopen = %v version %d, want Confirmed version 2", o.status, o.version)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 1 {
+		t.Errorf("journal has %d lines after compaction, want 1:\n%s", lines, data)
+	}
+
+	//writes still go to the compacted file
+	if _, err := reopened.updateStatus("101", Prepared, "manish", o.version); err != nil {
+		t.Fatal(err)
+	}
+	again, err := openFileOrderRepository(path, orderRepositoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if o, _ := again.get("101"); o.status != Prepared {
+		t.Errorf("status after write to compacted file = %v, want Prepared", o.status)
+	}
+}
+
+func TestRepositoryUsesItsClock(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	repo := newMemoryOrderRepository(orderRepositoryOptions{clock: fake})
+	created, err := repo.create(order{id: "1", amount: money.MustParse("1.00", "INR"), status: Recieved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(time.Hour)
+	updated, err := repo.updateStatus("1", Confrimed, "manish", created.version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.createdAt.Equal(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("createdAt = %v, want the fake clock time", created.createdAt)
+	}
+	if !updated.history[0].at.Equal(fake.Now()) {
+		t.Errorf("history at = %v, want %v", updated.history[0].at, fake.Now())
+	}
+}
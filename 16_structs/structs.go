@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"os"
 	"time"
+
+	"learngo/internal/money"
 )
 
 /*
@@ -12,30 +16,76 @@ Works as blueprint
 */
 
 type order struct { //making struct of order syntax is: type struct_name struct
-	id        string
-	amount    float32
-	status    string
-	createdAt time.Time //nanosecond
+	id         string
+	customerID string
+	amount     money.Money //minor units + currency, float32 was loosing paise
+	status     OrderStatus //typed status instead of free string, so "shiped" typo can not compile
+	createdAt  time.Time   //nanosecond
+	history    []transition
+	version    int //incremented on every save, used by OrderRepository for optimistic locking
+	paymentID  string
+	paid       money.Money    //captured amount, set by PaymentCaptured event
+	events     *orderEventLog //optional, every status change is also appended here
+	clock      Clock          //time for the history, nil -> real time
 }
 
 // reciever method type -> how to relate the methods in structs
-func (o *order) changeStatus(status string) { //if we dont pass by ref it will not change -> only update when we pass by ref
+func (o *order) changeStatus(status OrderStatus, by string) error { //if we dont pass by ref it will not change -> only update when we pass by ref
+	if err := canTransition(o.status, status); err != nil {
+		return err
+	}
+	at := o.now()
+	if o.events != nil { //log says when it happened, so history and event log show the same time
+		e, err := o.events.changeStatus(o.id, status, by)
+		if err != nil {
+			return err
+		}
+		at = e.At
+	}
+	o.setStatus(status, by, at)
+	return nil
+}
+
+// setStatus records the move in history, the transition must be checked already
+func (o *order) setStatus(status OrderStatus, by string, at time.Time) {
+	o.history = append(o.history, transition{from: o.status, to: status, by: by, at: at})
 	o.status = status //updating the status, struct doing deref automatically
 }
 
+func (o *order) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock.Now()
+}
+
+// String makes fmt.Println(order) readable, without it time.Time prints its wall/ext/loc internals
+func (o order) String() string {
+	return fmt.Sprintf("order %s: %v %v created %s", o.id, o.amount, o.status, o.createdAt.Format("2006-01-02 15:04:05"))
+}
+
 // getter
-func (o order) getAmount() float32 {
+func (o order) getAmount() money.Money {
 	return o.amount
 }
 
 func main() {
+	//go run . serve -> only the REST API on localhost:8080 (ORDER_API_ADDR to change), none of the demos below run
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		addr := apiAddr()
+		fmt.Println("listening on", addr)
+		if err := http.ListenAndServe(addr, newServeAPI()); err != nil {
+			fmt.Println("error:", err)
+		}
+		return
+	}
 
 	//making instance, if we are not setting the val of any field by default that will be the zero val of the type i.e int = 0
 
 	myOrder := order{
 		id:     "1",
-		amount: 45.00,
-		status: "recieved",
+		amount: money.MustParse("45.00", "INR"),
+		status: Recieved,
 		//no need to pass all filds
 	}
 	//we can access the fields by '.' like javascript
@@ -44,15 +94,29 @@ func main() {
 	fmt.Println(myOrder)
 
 	//changeStatus("shipped") // give error ndefined: changeStatus
-	myOrder.changeStatus("shipped")
+	if err := myOrder.changeStatus(Shipped, "manish"); err != nil {
+		fmt.Println("error:", err) //recieved -> shipped is not allowed, it must be confirmed and prepared first
+	}
+	if err := myOrder.changeStatus(Confrimed, "manish"); err != nil {
+		fmt.Println("error:", err)
+	}
 	fmt.Println("amount of the order is: ", myOrder.getAmount())
 
 	fmt.Println("after updated status", myOrder)
 
-	firstStudent := newStudent("1", "manish", "10th") // this first student is itself a pointer because of constructor function
-	fmt.Println(firstStudent)                         //now go automatically deref this for us thats why the value at the addres this pointer is pointing is displaying in terminal
+	firstStudent, err := newStudent("1", "manish", "10th", realClock{}) // this first student is itself a pointer because of constructor function
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println(firstStudent) //now go automatically deref this for us thats why the value at the addres this pointer is pointing is displaying in terminal
 	fmt.Println(firstStudent.name)
 
+	//constructor tells all the wrong fields together
+	if _, err := newStudent("", "ajay", "13th", realClock{}); err != nil {
+		fmt.Println("error:", err)
+	}
+
 	//inline structs used of declearing something
 	language := struct {
 		name   string
@@ -60,4 +124,9 @@ func main() {
 	}{"goLang", true}
 
 	fmt.Println(language)
+
+	repositoryDemo()
+	eventsDemo()
+	registryDemo()
+	apiDemo()
 }
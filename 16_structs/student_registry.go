@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+registry keeps all students of the school, every student goes in through newStudent so validation is same everywhere
+withdraw does not delete, it sets withdrawnAt so we still have the record, rosters and search only show enrolled students
+CSV is for the office people who work in excel: id,name,class,createdAt,withdrawnAt
+export has withdrawn students too (withdrawnAt filled), so export -> import gives back the same registry
+excel runs a cell starting with = + - @ as a formula, so such cells get a ' in front on export and lose it on import
+*/
+
+var (
+	errStudentNotFound = errors.New("student not found")
+	errStudentExists   = errors.New("student already enrolled")
+)
+
+var studentCSVHeader = []string{"id", "name", "class", "createdAt", "withdrawnAt"}
+
+// csvSafe stops a name like "=HYPERLINK(...)" from running as a formula when the file is opened in excel
+func csvSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// csvUnsafe undoes csvSafe
+func csvUnsafe(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+type studentRegistry struct {
+	clock Clock //enrol and withdraw time
+
+	mu       sync.Mutex
+	students map[string]*students
+}
+
+func newStudentRegistry(clock Clock) *studentRegistry {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &studentRegistry{clock: clock, students: map[string]*students{}}
+}
+
+func (r *studentRegistry) enrol(id string, name string, class string) (students, error) {
+	s, err := newStudent(id, name, class, r.clock)
+	if err != nil {
+		return students{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.students[s.id]; ok && existing.withdrawnAt.IsZero() { //s.id is trimmed, id may not be
+		return students{}, fmt.Errorf("%w: %s", errStudentExists, s.id)
+	}
+	r.students[s.id] = s //a withdrawn student can join again with the same id
+	return *s, nil
+}
+
+// active student or error, caller holds the lock
+func (r *studentRegistry) enrolled(id string) (*students, error) {
+	id = strings.TrimSpace(id)
+	s, ok := r.students[id]
+	if !ok || !s.withdrawnAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s", errStudentNotFound, id)
+	}
+	return s, nil
+}
+
+func (r *studentRegistry) transfer(id string, class string) (students, error) {
+	if !knownClasses[class] {
+		return students{}, &validationError{fields: []fieldError{{Field: "class", Message: fmt.Sprintf("%q is not a known class", class)}}}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, err := r.enrolled(id)
+	if err != nil {
+		return students{}, err
+	}
+	s.class = class
+	return *s, nil
+}
+
+func (r *studentRegistry) withdraw(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, err := r.enrolled(id)
+	if err != nil {
+		return err
+	}
+	s.withdrawnAt = r.clock.Now()
+	return nil
+}
+
+// returns copies of enrolled students sorted by name (then id when names are same)
+func (r *studentRegistry) collect(match func(s *students) bool) []students {
+	return r.collectAll(func(s *students) bool { return s.withdrawnAt.IsZero() && match(s) })
+}
+
+// collectAll is collect with withdrawn students too
+func (r *studentRegistry) collectAll(match func(s *students) bool) []students {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []students
+	for _, s := range r.students {
+		if match(s) {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := strings.ToLower(out[i].name), strings.ToLower(out[j].name)
+		if a != b {
+			return a < b
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
+
+func (r *studentRegistry) roster(class string) []students {
+	return r.collect(func(s *students) bool { return s.class == class })
+}
+
+// case insensitive, "man" finds "Manish"
+func (r *studentRegistry) searchByName(prefix string) []students {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	return r.collect(func(s *students) bool { return strings.HasPrefix(strings.ToLower(s.name), prefix) })
+}
+
+func (r *studentRegistry) exportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(studentCSVHeader); err != nil {
+		return err
+	}
+	for _, s := range r.collectAll(func(*students) bool { return true }) {
+		row := []string{csvSafe(s.id), csvSafe(s.name), s.class, formatCSVTime(s.createdAt), formatCSVTime(s.withdrawnAt)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+/*
+importCSV is all or nothing, every row is checked first and all bad rows are reported together
+createdAt column is optional, empty means now, withdrawnAt column is optional, empty means still enrolled
+*/
+func (r *studentRegistry) importCSV(rd io.Reader) (int, error) {
+	cr := csv.NewReader(rd)
+	cr.FieldsPerRecord = -1 //we check the column count ourself for better message
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("reading student csv: %w", err)
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "id") {
+		rows = rows[1:] //header
+	}
+
+	var parsed []*students
+	var problems []string
+	seen := map[string]bool{}
+	for i, row := range rows {
+		line := i + 2
+		if len(row) < 3 {
+			problems = append(problems, fmt.Sprintf("line %d: want at least 3 columns, got %d", line, len(row)))
+			continue
+		}
+		s, err := newStudent(csvUnsafe(strings.TrimSpace(row[0])), csvUnsafe(strings.TrimSpace(row[1])), strings.TrimSpace(row[2]), r.clock)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if len(row) > 3 && strings.TrimSpace(row[3]) != "" {
+			createdAt, err := time.Parse(time.RFC3339, strings.TrimSpace(row[3]))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: createdAt must be RFC3339", line))
+				continue
+			}
+			s.createdAt = createdAt
+		}
+		if len(row) > 4 && strings.TrimSpace(row[4]) != "" {
+			withdrawnAt, err := time.Parse(time.RFC3339, strings.TrimSpace(row[4]))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: withdrawnAt must be RFC3339", line))
+				continue
+			}
+			s.withdrawnAt = withdrawnAt
+		}
+		if seen[s.id] {
+			problems = append(problems, fmt.Sprintf("line %d: duplicate id %s", line, s.id))
+			continue
+		}
+		seen[s.id] = true
+		parsed = append(parsed, s)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range parsed {
+		if existing, ok := r.students[s.id]; ok && existing.withdrawnAt.IsZero() {
+			problems = append(problems, fmt.Sprintf("%v: %s", errStudentExists, s.id))
+		}
+	}
+	if len(problems) > 0 {
+		return 0, fmt.Errorf("student csv not imported:\n  %s", strings.Join(problems, "\n  "))
+	}
+	for _, s := range parsed {
+		r.students[s.id] = s
+	}
+	return len(parsed), nil
+}
+
+func registryDemo() {
+	fmt.Println("+++++STUDENT REGISTRY+++++")
+	registry := newStudentRegistry(realClock{})
+	registry.enrol("1", "manish", "10th")
+	registry.enrol("2", "ajay", "10th")
+	registry.enrol("3", "Manoj", "9th")
+	registry.transfer("3", "10th")
+	registry.withdraw("2")
+
+	for _, s := range registry.roster("10th") {
+		fmt.Println("10th:", s.id, s.name)
+	}
+	for _, s := range registry.searchByName("MAN") {
+		fmt.Println("search man:", s.name, s.class)
+	}
+
+	imported, err := registry.importCSV(strings.NewReader("id,name,class\n4,sagar,12th\n5,,13th\n"))
+	fmt.Println("imported", imported, "error:", err)
+	imported, _ = registry.importCSV(strings.NewReader("id,name,class\n4,sagar,12th\n"))
+	fmt.Println("imported", imported)
+
+	registry.exportCSV(os.Stdout)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrolTrimsID(t *testing.T) {
+	r := newStudentRegistry(nil)
+	if _, err := r.enrol(" 1 ", "manish", "10th"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.enrol("1", "manish", "10th"); !errors.Is(err, errStudentExists) {
+		t.Errorf("second enrol of id 1 = %v, want errStudentExists", err)
+	}
+	if err := r.withdraw("1"); err != nil {
+		t.Errorf("withdraw 1: %v", err)
+	}
+}
+
+func TestExportImportKeepsWithdrawnAndGuardsFormulas(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	r := newStudentRegistry(fake)
+	r.enrol("1", "=HYPERLINK(\"http://evil\")", "10th")
+	r.enrol("2", "ajay", "10th")
+	fake.Advance(time.Hour)
+	r.withdraw("2")
+
+	var out bytes.Buffer
+	if err := r.exportCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	csv := out.String()
+	if !strings.Contains(csv, `"'=HYPERLINK(""http://evil"")"`) {
+		t.Errorf("formula cell not guarded:\n%s", csv)
+	}
+	if !strings.Contains(csv, "2,ajay,10th,2026-01-01T10:00:00Z,2026-01-01T11:00:00Z") {
+		t.Errorf("withdrawn student missing from export:\n%s", csv)
+	}
+
+	restored := newStudentRegistry(fake)
+	if n, err := restored.importCSV(strings.NewReader(csv)); err != nil || n != 2 {
+		t.Fatalf("import = %d, %v", n, err)
+	}
+	if found := restored.searchByName("=HYPER"); len(found) != 1 {
+		t.Errorf("name did not come back without the guard quote: %+v", found)
+	}
+	if roster := restored.roster("10th"); len(roster) != 1 {
+		t.Errorf("withdrawn student came back as enrolled: %+v", roster)
+	}
+}
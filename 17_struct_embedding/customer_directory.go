@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"learngo/internal/money"
+)
+
+/*
+embedding copies the customer into the order, so changing newOrder.customer.name does not change the master record (see main)
+that copy is fine as a snapshot ("who was the customer when they ordered") but it can not be the link
+so directory gives every customer a stable id, orders keep customerID and snapshot is optional
+
+same person often gets created twice ("Manish " with +91 98765 43210 and "manish" with 9876543210)
+findDuplicates groups them by phone in E.164 form (or name when phone is missing) and only proposes merges
+a wrong merge mixes two people's orders, so a person (or the caller) must confirm every candidate before merge
+merge keeps one record, moves all orders to it and remembers old id -> new id so old ids still resolve
+*/
+
+var errUnknownCustomer = errors.New("unknown customer")
+
+type customerDirectory struct {
+	countryCode string //phones without +country are in this country, "91" for India
+
+	mu         sync.Mutex
+	nextID     int
+	customers  map[string]customer
+	seq        map[string]int    //id -> order of creation, "cus_10000" sorts before "cus_9999" as text
+	mergedInto map[string]string //old id -> id it was merged into
+	orders     map[string]*order
+}
+
+func newCustomerDirectory(countryCode string) *customerDirectory {
+	return &customerDirectory{
+		countryCode: strings.TrimPrefix(countryCode, "+"),
+		nextID:      1,
+		customers:   map[string]customer{},
+		seq:         map[string]int{},
+		mergedInto:  map[string]string{},
+		orders:      map[string]*order{},
+	}
+}
+
+// id is given by the directory, caller can not choose it so it never changes
+func (d *customerDirectory) add(name string, phone string) customer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := customer{id: fmt.Sprintf("cus_%04d", d.nextID), name: strings.TrimSpace(name), phone: strings.TrimSpace(phone)}
+	d.seq[c.id] = d.nextID
+	d.nextID++
+	d.customers[c.id] = c
+	return c
+}
+
+// resolve follows merges, so an id from before a merge still finds the customer, caller holds the lock
+func (d *customerDirectory) resolve(id string) (customer, error) {
+	for i := 0; i < len(d.mergedInto)+1; i++ {
+		if c, ok := d.customers[id]; ok {
+			return c, nil
+		}
+		next, ok := d.mergedInto[id]
+		if !ok {
+			break
+		}
+		id = next
+	}
+	return customer{}, fmt.Errorf("%w: %s", errUnknownCustomer, id)
+}
+
+func (d *customerDirectory) get(id string) (customer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resolve(id)
+}
+
+func (d *customerDirectory) update(id string, name string, phone string) (customer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, err := d.resolve(id)
+	if err != nil {
+		return customer{}, err
+	}
+	c.name, c.phone = strings.TrimSpace(name), strings.TrimSpace(phone)
+	d.customers[c.id] = c
+	return c, nil
+}
+
+// placeOrder links the order by id, with snapshot=true the current customer is also copied into the embedded field
+func (d *customerDirectory) placeOrder(id string, customerID string, amount money.Money, snapshot bool) (*order, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, err := d.resolve(customerID)
+	if err != nil {
+		return nil, err
+	}
+	o := &order{id: id, amount: amount, status: "recieved", customerID: c.id}
+	if snapshot {
+		o.customer = c //copy by value, this is exactly what we want here
+	}
+	d.orders[id] = o
+	return o, nil
+}
+
+// customerOf gives the current master record, not the snapshot
+func (d *customerDirectory) customerOf(o *order) (customer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resolve(o.customerID)
+}
+
+/*
+normalizePhone gives the E.164 form "+<country><number>", "" when it can not be a phone number
+- "+91 98765-43210" -> +919876543210 (already has the country)
+- "0091 98765 43210" -> +919876543210 (00 is the international prefix)
+- "098765 43210" -> +919876543210 (0 is the trunk prefix inside the country)
+- "9876543210" -> +919876543210 (no prefix, countryCode is used)
+only taking the last 10 digits made +1 212 555 0100 and +44 212 555 0100 the same person
+*/
+func normalizePhone(phone string, countryCode string) string {
+	phone = strings.TrimSpace(phone)
+	international := strings.HasPrefix(phone, "+")
+	var b strings.Builder
+	for _, r := range phone {
+		switch {
+		case unicode.IsDigit(r):
+			b.WriteRune(r)
+		case strings.ContainsRune(" -.()/", r) || (r == '+' && b.Len() == 0):
+			//separators people type
+		default:
+			return "" //letters, extension marks, a + in the middle
+		}
+	}
+	digits := b.String()
+	switch {
+	case international:
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case countryCode == "":
+		return "" //no country known, can not make it international
+	default:
+		digits = countryCode + strings.TrimPrefix(digits, "0")
+	}
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' { //E.164 is at most 15 digits, country codes dont start with 0
+		return ""
+	}
+	return "+" + digits
+}
+
+// lower case and single spaces, "  Manish   Tomar" -> "manish tomar"
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+func (d *customerDirectory) duplicateKey(c customer) string {
+	if phone := normalizePhone(c.phone, d.countryCode); phone != "" {
+		return "phone:" + phone
+	}
+	return "name:" + normalizeName(c.name)
+}
+
+// mergeCandidate is a proposal, nothing is merged until the caller passes it to merge
+type mergeCandidate struct {
+	keepID       string   //oldest customer of the group
+	duplicateIDs []string //newer ones, in creation order
+	reason       string   //what matched, "phone:+919876543210" or "name:manish"
+}
+
+// findDuplicates returns groups of customers which look like the same person, oldest customer first
+func (d *customerDirectory) findDuplicates() []mergeCandidate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	groups := map[string][]string{}
+	for id, c := range d.customers {
+		key := d.duplicateKey(c)
+		groups[key] = append(groups[key], id)
+	}
+	var out []mergeCandidate
+	for key, ids := range groups {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool { return d.seq[ids[i]] < d.seq[ids[j]] })
+		out = append(out, mergeCandidate{keepID: ids[0], duplicateIDs: ids[1:], reason: key})
+	}
+	sort.Slice(out, func(i, j int) bool { return d.seq[out[i].keepID] < d.seq[out[j].keepID] })
+	return out
+}
+
+// merge keeps keepID, removes the duplicates and re-points their orders, returns how many orders moved
+func (d *customerDirectory) merge(keepID string, duplicateIDs ...string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keep, err := d.resolve(keepID)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, dupID := range duplicateIDs {
+		dup, err := d.resolve(dupID)
+		if err != nil {
+			return moved, err
+		}
+		if dup.id == keep.id {
+			continue
+		}
+		for _, o := range d.orders {
+			if o.customerID == dup.id {
+				o.customerID = keep.id //snapshot stays as it was, it is history
+				moved++
+			}
+		}
+		delete(d.customers, dup.id)
+		d.mergedInto[dup.id] = keep.id
+	}
+	return moved, nil
+}
+
+// sameNames is the demo stand-in for a person saying "yes, same customer"
+func sameNames(dir *customerDirectory, candidate mergeCandidate) bool {
+	keep, err := dir.get(candidate.keepID)
+	if err != nil {
+		return false
+	}
+	for _, id := range candidate.duplicateIDs {
+		dup, err := dir.get(id)
+		if err != nil || !strings.HasPrefix(normalizeName(keep.name), normalizeName(dup.name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func directoryDemo() {
+	fmt.Println("+++++CUSTOMER DIRECTORY+++++")
+	dir := newCustomerDirectory("91")
+	manish := dir.add("Manish", "+91 98765-43210")
+	again := dir.add("  manish ", "9876543210") //same person created again
+	ajay := dir.add("ajay", "12121212")
+
+	first, _ := dir.placeOrder("1", manish.id, money.MustParse("50.09", "INR"), true)
+	second, _ := dir.placeOrder("2", again.id, money.MustParse("20.00", "INR"), false)
+	dir.placeOrder("3", ajay.id, money.MustParse("10.00", "INR"), false)
+
+	//master record changes, snapshot in the order does not
+	dir.update(manish.id, "Manish Tomar", manish.phone)
+	current, _ := dir.customerOf(first)
+	fmt.Println("snapshot:", first.customer.name, "current:", current.name)
+
+	//support person looks at every candidate, here we say yes to the ones where the names match too
+	moved := 0
+	for _, candidate := range dir.findDuplicates() {
+		fmt.Println("duplicate?", candidate.keepID, candidate.duplicateIDs, "because", candidate.reason)
+		if !sameNames(dir, candidate) {
+			fmt.Println("not merged, names are different")
+			continue
+		}
+		n, err := dir.merge(candidate.keepID, candidate.duplicateIDs...)
+		if err != nil {
+			fmt.Println("error:", err)
+		}
+		moved += n
+	}
+	fmt.Println("orders moved:", moved, "order 2 now belongs to", second.customerID)
+
+	old, _ := dir.get(again.id) //old id still works after merge
+	fmt.Println(again.id, "resolves to", old.id, old.name)
+}
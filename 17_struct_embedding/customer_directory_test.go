@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+91 98765-43210", "+919876543210"},
+		{"0091 98765 43210", "+919876543210"},
+		{"098765 43210", "+919876543210"},
+		{"9876543210", "+919876543210"},
+		{"+1 (212) 555-0100", "+12125550100"},
+		{"+44 212 555 0100", "+442125550100"},
+		{"12ab34", ""},
+		{"+91 98765 43210 ext 5", ""},
+		{"123", ""},
+		{"+1234567890123456", ""}, //16 digits, longer than E.164 allows
+	}
+	for _, tt := range tests {
+		if got := normalizePhone(tt.phone, "91"); got != tt.want {
+			t.Errorf("normalizePhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestSameLastDigitsInOtherCountriesAreNotDuplicates(t *testing.T) {
+	dir := newCustomerDirectory("91")
+	dir.add("john", "+1 212 555 0100")
+	dir.add("john", "+44 212 555 0100")
+	if found := dir.findDuplicates(); len(found) != 0 {
+		t.Errorf("findDuplicates = %+v, want none", found)
+	}
+}
+
+func TestFindDuplicatesOnlyProposes(t *testing.T) {
+	dir := newCustomerDirectory("91")
+	dir.nextID = 9999 //cus_9999 and cus_10000, as text cus_10000 is first
+	older := dir.add("manish", "9876543210")
+	newer := dir.add("Manish", "+91 98765 43210")
+
+	found := dir.findDuplicates()
+	if len(found) != 1 {
+		t.Fatalf("findDuplicates = %+v, want one candidate", found)
+	}
+	if found[0].keepID != older.id || len(found[0].duplicateIDs) != 1 || found[0].duplicateIDs[0] != newer.id {
+		t.Errorf("candidate = %+v, want keep %s and merge %s", found[0], older.id, newer.id)
+	}
+	c, err := dir.get(newer.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.id != newer.id {
+		t.Errorf("%s was merged without confirmation", newer.id)
+	}
+
+	if _, err := dir.merge(found[0].keepID, found[0].duplicateIDs...); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = dir.get(newer.id); c.id != older.id {
+		t.Errorf("after merge %s resolves to %s, want %s", newer.id, c.id, older.id)
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"learngo/internal/money"
+)
 
 type customer struct {
 	id    string
@@ -9,21 +13,22 @@ type customer struct {
 }
 
 type order struct {
-	id       string
-	amount   float32
-	status   string
-	customer //struct embedding
+	id         string
+	amount     money.Money
+	status     string
+	customerID string //points to the master record in customerDirectory, this is the real link
+	customer          //struct embedding -> copy of the customer at order time (snapshot), editing it does not change the directory
 }
 
 func main() {
 
 	newOrder := order{
 		id:     "1",
-		amount: 50.09,
+		amount: money.MustParse("50.09", "INR"), //exact, float32 50.09 is really 50.090000152
 		status: "shipped",
 	}
 
-	fmt.Println(newOrder) // {1 50.09 shipped {  }} -> {   } is emoty string (Zero values) of string
+	fmt.Println(newOrder) // {1 {5009 INR} shipped  {  }} -> {   } is emoty string (Zero values) of string
 
 	newCustomer := customer{
 		id:    "1",
@@ -31,10 +36,11 @@ func main() {
 		phone: "12121212",
 	}
 	newOrder.customer = newCustomer
-	fmt.Println(newOrder) // {1 50.09 shipped {1 manish 12121212}} -> now taking the newCustomer
+	fmt.Println(newOrder) // {1 {5009 INR} shipped  {1 manish 12121212}} -> now taking the newCustomer
 
 	newOrder.customer.name = "ajay" //means the order now have seprate customer and we can update the fields
-	fmt.Println(newOrder)           // {1 50.09 shipped {1 ajay 12121212}} -> now taking the newCustomer
+	fmt.Println(newOrder)           // {1 {5009 INR} shipped  {1 ajay 12121212}} -> now taking the newCustomer
 	fmt.Println(newCustomer)
 
+	directoryDemo()
 }
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"learngo/internal/money"
+)
+
+/*
+pay takes the money in one step, but real shops also need
+authorize (block the money on the card) -> capture (take it later when we ship) or void (release it)
+and refund (full or partial) after capture
+not every gateway supports everything, so instead of making paymenter bigger we add small optional interfaces
+and check at runtime with type assertion -> if r, ok := gateway.(refunder); ok { ... }
+same idea as io.Reader and io.WriterTo in the standard library
+*/
+
+type authorizer interface {
+	authorize(ctx context.Context, amount money.Money) (paymentResult, error)
+}
+
+type capturer interface {
+	capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error)
+}
+
+type voider interface {
+	void(ctx context.Context, transactionID string) (paymentResult, error)
+}
+
+type refunder interface {
+	refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error)
+}
+
+const (
+	paymentAuthorized        paymentStatus = "authorized"
+	paymentVoided            paymentStatus = "voided"
+	paymentPartiallyRefunded paymentStatus = "partially_refunded"
+	paymentRefunded          paymentStatus = "refunded"
+)
+
+var (
+	errNotSupported          = errors.New("operation not supported by gateway")
+	errTransactionNotFound   = errors.New("transaction not found")
+	errNotAuthorized         = errors.New("transaction is not in authorized state")
+	errCaptureExceedsAuth    = errors.New("capture amount exceeds authorized amount")
+	errRefundExceedsCaptured = errors.New("refund amount exceeds captured amount")
+)
+
+type refundResult struct {
+	refundID      string
+	transactionID string
+	amount        money.Money
+	remaining     money.Money //what can still be refunded
+	status        paymentStatus
+}
+
+type txState struct {
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+	status     paymentStatus
+}
+
+// txLedger is the book keeping of one gateway (fakePayment and the stub servers), zero value is ready to use
+type txLedger struct {
+	mu  sync.Mutex
+	txs map[string]*txState
+}
+
+func (l *txLedger) put(id string, tx *txState) {
+	if l.txs == nil {
+		l.txs = map[string]*txState{}
+	}
+	l.txs[id] = tx
+}
+
+// pay = authorize + capture in one go
+func (l *txLedger) charge(id string, amount money.Money) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.put(id, &txState{authorized: amount, captured: amount, refunded: money.FromMinor(0, amount.Currency()), status: paymentSucceeded})
+}
+
+func (l *txLedger) authorize(id string, amount money.Money) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	zero := money.FromMinor(0, amount.Currency())
+	l.put(id, &txState{authorized: amount, captured: zero, refunded: zero, status: paymentAuthorized})
+}
+
+// partial capture is allowed, rest of the authorized money is released
+func (l *txLedger) capture(id string, amount money.Money) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tx, ok := l.txs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", errTransactionNotFound, id)
+	}
+	if tx.status != paymentAuthorized {
+		return fmt.Errorf("%w: %s is %s", errNotAuthorized, id, tx.status)
+	}
+	if err := tx.authorized.SameCurrency(amount); err != nil {
+		return err
+	}
+	if !amount.IsPositive() || amount.Minor() > tx.authorized.Minor() {
+		return fmt.Errorf("%w: capture %v of %v", errCaptureExceedsAuth, amount, tx.authorized)
+	}
+	tx.captured = amount
+	tx.status = paymentSucceeded
+	return nil
+}
+
+func (l *txLedger) void(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tx, ok := l.txs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", errTransactionNotFound, id)
+	}
+	if tx.status != paymentAuthorized { //captured money can only be refunded
+		return fmt.Errorf("%w: %s is %s", errNotAuthorized, id, tx.status)
+	}
+	tx.status = paymentVoided
+	return nil
+}
+
+// refund never goes above what was captured, sum of all partial refunds included
+func (l *txLedger) refund(id string, amount money.Money) (refundResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tx, ok := l.txs[id]
+	if !ok {
+		return refundResult{}, fmt.Errorf("%w: %s", errTransactionNotFound, id)
+	}
+	if err := tx.captured.SameCurrency(amount); err != nil {
+		return refundResult{}, err
+	}
+	remaining, _ := tx.captured.Subtract(tx.refunded)
+	if !amount.IsPositive() || amount.Minor() > remaining.Minor() {
+		return refundResult{}, fmt.Errorf("%w: refund %v, refundable %v", errRefundExceedsCaptured, amount, remaining)
+	}
+	tx.refunded, _ = tx.refunded.Add(amount)
+	remaining, _ = remaining.Subtract(amount)
+	tx.status = paymentPartiallyRefunded
+	if remaining.IsZero() {
+		tx.status = paymentRefunded
+	}
+	return refundResult{transactionID: id, amount: amount, remaining: remaining, status: tx.status}, nil
+}
+
+// fakePayment, fails the same way as pay when err is set
+
+func (fp *fakePayment) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return paymentResult{}, err
+	}
+	if err := fp.nextCall(); err != nil {
+		return paymentResult{gateway: "fakePayment", status: paymentFailed}, err
+	}
+	id := newTransactionID("fake")
+	fp.ledger.authorize(id, amount)
+	return paymentResult{transactionID: id, gateway: "fakePayment", status: paymentAuthorized}, nil
+}
+
+func (fp *fakePayment) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return paymentResult{}, err
+	}
+	if err := fp.nextCall(); err != nil {
+		return paymentResult{}, err
+	}
+	if err := fp.ledger.capture(transactionID, amount); err != nil {
+		return paymentResult{}, &paymentError{gateway: "fakePayment", reason: err}
+	}
+	return paymentResult{transactionID: transactionID, gateway: "fakePayment", status: paymentSucceeded}, nil
+}
+
+func (fp *fakePayment) void(ctx context.Context, transactionID string) (paymentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return paymentResult{}, err
+	}
+	if err := fp.nextCall(); err != nil {
+		return paymentResult{}, err
+	}
+	if err := fp.ledger.void(transactionID); err != nil {
+		return paymentResult{}, &paymentError{gateway: "fakePayment", reason: err}
+	}
+	return paymentResult{transactionID: transactionID, gateway: "fakePayment", status: paymentVoided}, nil
+}
+
+func (fp *fakePayment) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	if err := ctx.Err(); err != nil {
+		return refundResult{}, err
+	}
+	if err := fp.nextCall(); err != nil {
+		return refundResult{}, err
+	}
+	result, err := fp.ledger.refund(transactionID, amount)
+	if err != nil {
+		return refundResult{}, &paymentError{gateway: "fakePayment", reason: err}
+	}
+	result.refundID = newTransactionID("fake_rfnd")
+	return result, nil
+}
+
+/*
+wrappers are gateways too, so they forward the capabilities of the gateway inside
+- retryingGateway retries authorize, capture, void and refund like pay,
+  every attempt has the same Idempotency-Key so a lost answer can not charge or refund two times
+- failoverGateway authorizes on the first healthy member and remembers it,
+  capture/void/refund go back to that member because the money is blocked there
+*/
+
+func (r retryingGateway) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	a, ok := r.gateway.(authorizer)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("authorize: %w", errNotSupported)
+	}
+	return retryCall(ctx, r, func(ctx context.Context) (paymentResult, error) { return a.authorize(ctx, amount) })
+}
+
+func (r retryingGateway) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	c, ok := r.gateway.(capturer)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("capture: %w", errNotSupported)
+	}
+	return retryCall(ctx, r, func(ctx context.Context) (paymentResult, error) { return c.capture(ctx, transactionID, amount) })
+}
+
+func (r retryingGateway) void(ctx context.Context, transactionID string) (paymentResult, error) {
+	v, ok := r.gateway.(voider)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("void: %w", errNotSupported)
+	}
+	return retryCall(ctx, r, func(ctx context.Context) (paymentResult, error) { return v.void(ctx, transactionID) })
+}
+
+func (r retryingGateway) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	rf, ok := r.gateway.(refunder)
+	if !ok {
+		return refundResult{}, fmt.Errorf("refund: %w", errNotSupported)
+	}
+	return retryCall(ctx, r, func(ctx context.Context) (refundResult, error) { return rf.refund(ctx, transactionID, amount) })
+}
+
+func (f *failoverGateway) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return f.first(ctx, func(gateway paymenter) (paymentResult, error) {
+		a, ok := gateway.(authorizer)
+		if !ok {
+			return paymentResult{}, fmt.Errorf("authorize: %w", errNotSupported)
+		}
+		return a.authorize(ctx, amount)
+	})
+}
+
+func (f *failoverGateway) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	gateway, err := f.owner(transactionID)
+	if err != nil {
+		return paymentResult{}, err
+	}
+	return payment{gateway: gateway}.capture(ctx, transactionID, amount)
+}
+
+func (f *failoverGateway) void(ctx context.Context, transactionID string) (paymentResult, error) {
+	gateway, err := f.owner(transactionID)
+	if err != nil {
+		return paymentResult{}, err
+	}
+	return payment{gateway: gateway}.void(ctx, transactionID)
+}
+
+func (f *failoverGateway) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	gateway, err := f.owner(transactionID)
+	if err != nil {
+		return refundResult{}, err
+	}
+	return payment{gateway: gateway}.refund(ctx, transactionID, amount)
+}
+
+// payment side, asks the gateway if it can do the operation
+
+func (p payment) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	a, ok := p.gateway.(authorizer)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("authorize: %w", errNotSupported)
+	}
+	if !amount.IsPositive() {
+		return paymentResult{}, fmt.Errorf("invalid payment amount %v", amount)
+	}
+	return a.authorize(ctx, amount)
+}
+
+func (p payment) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	c, ok := p.gateway.(capturer)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("capture: %w", errNotSupported)
+	}
+	return c.capture(ctx, transactionID, amount)
+}
+
+func (p payment) void(ctx context.Context, transactionID string) (paymentResult, error) {
+	v, ok := p.gateway.(voider)
+	if !ok {
+		return paymentResult{}, fmt.Errorf("void: %w", errNotSupported)
+	}
+	return v.void(ctx, transactionID)
+}
+
+func (p payment) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	r, ok := p.gateway.(refunder)
+	if !ok {
+		return refundResult{}, fmt.Errorf("refund: %w", errNotSupported)
+	}
+	return r.refund(ctx, transactionID, amount)
+}
+
+func refundDemo(amount money.Money) {
+	fmt.Println("+++++AUTHORIZE, CAPTURE, REFUND+++++")
+	ctx := context.Background()
+	stripeStub := newStripeStub(stubOptions{})
+	defer stripeStub.Close()
+	p := payment{gateway: &stripe{baseURL: stripeStub.URL, secretKey: "sk_test_local"}}
+
+	auth, err := p.authorize(ctx, amount)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	partial := money.MustParse("80.00", "INR")
+	if _, err := p.capture(ctx, auth.transactionID, partial); err != nil { //ship only part of the order
+		fmt.Println("error:", err)
+	}
+
+	first, err := p.refund(ctx, auth.transactionID, money.MustParse("30.00", "INR"))
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("refunded", first.amount, "remaining", first.remaining, first.status)
+
+	//only 50.00 is left, 60.00 must fail
+	if _, err := p.refund(ctx, auth.transactionID, money.MustParse("60.00", "INR")); errors.Is(err, errRefundExceedsCaptured) {
+		fmt.Println("error:", err)
+	}
+
+	//production chain: failover over retrying gateways still supports the whole flow
+	chain := &failoverGateway{}
+	chain.add("stripe", retryingGateway{gateway: &stripe{baseURL: stripeStub.URL, secretKey: "sk_test_local"}, policy: defaultRetryPolicy()}, defaultBreakerConfig())
+	wrapped := payment{gateway: chain}
+	auth, err = wrapped.authorize(ctx, amount)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if _, err := wrapped.capture(ctx, auth.transactionID, amount); err != nil {
+		fmt.Println("error:", err)
+	}
+	refund, err := wrapped.refund(ctx, auth.transactionID, partial)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("refunded through failover+retry", refund.amount, "remaining", refund.remaining, refund.status)
+}
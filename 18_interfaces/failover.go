@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"learngo/internal/money"
+)
+
+/*
+circuit breaker is like the fuse in our house
+closed -> everything normal, calls go to the gateway, we count failures one after another
+open -> too many failures, we dont call the gateway at all for coolDown time (give it time to recover)
+half-open -> coolDown is over, we let one trial call go, success closes the breaker, failure opens it again
+
+failoverGateway takes gateways in order of preference (stripe then razorpay)
+and gives the payment to the first one whose breaker allows the call
+next gateway is tried only when the request surely never reached the last one (errGatewayUnreachable)
+after a timeout or 5xx the first gateway may have charged, sending it to the next could charge two times,
+so that gives errOutcomeUnknown and the caller has to look the payment up before paying again
+it is also a paymenter so payment{gateway: failover} works without any change
+*/
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("breakerState(%d)", int(s))
+}
+
+type breakerConfig struct {
+	failureThreshold int           //failures in a row before we open
+	coolDown         time.Duration //how long we stay open
+	successThreshold int           //successful trial calls in half-open before we close again
+}
+
+func defaultBreakerConfig() breakerConfig {
+	return breakerConfig{failureThreshold: 3, coolDown: 30 * time.Second, successThreshold: 1}
+}
+
+type circuitBreaker struct {
+	cfg breakerConfig
+	now func() time.Time //time.Now, replaceable in tests
+
+	mu          sync.Mutex
+	state       breakerState
+	failures    int
+	successes   int
+	probing     bool //a trial call is running in half-open state
+	openedAt    time.Time
+	lastErr     error
+	lastFailure time.Time
+}
+
+func newCircuitBreaker(cfg breakerConfig) *circuitBreaker {
+	if cfg.failureThreshold < 1 {
+		cfg.failureThreshold = 1
+	}
+	if cfg.successThreshold < 1 {
+		cfg.successThreshold = 1
+	}
+	return &circuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// allow tells if we can call the gateway now, it also moves open -> half-open after coolDown
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cfg.coolDown {
+		b.state = breakerHalfOpen
+		b.successes = 0
+	}
+	switch b.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if b.probing { //only one trial call at a time
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		if b.state == breakerHalfOpen {
+			b.successes++
+			if b.successes >= b.cfg.successThreshold {
+				b.state = breakerClosed
+			}
+		}
+		return
+	}
+
+	b.lastErr = err
+	b.lastFailure = b.now()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.failureThreshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// declined card or no money is the customer problem not the gateway, so it does not trip the breaker
+func isGatewayFault(err error) bool {
+	return err != nil && !errors.Is(err, errDeclined) && !errors.Is(err, errInsufficientFunds) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+type failoverMember struct {
+	name    string
+	gateway paymenter
+	breaker *circuitBreaker
+}
+
+type failoverGateway struct {
+	members []failoverMember
+
+	mu     sync.Mutex
+	owners map[string]paymenter //transaction id -> gateway which made it, capture/void/refund must go back there
+}
+
+var errNoGatewayAvailable = errors.New("no payment gateway available")
+
+// release ends a trial call which told nothing about gateway health (declined card, caller cancelled), state stays as it is
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// builds failover from registered gateway names in order of preference
+func newFailoverGateway(cfg breakerConfig, names ...string) (*failoverGateway, error) {
+	gatewayCfg, err := loadGatewayConfig()
+	if err != nil {
+		return nil, err
+	}
+	f := &failoverGateway{}
+	for _, name := range names {
+		gateway, err := buildGateway(name, gatewayCfg)
+		if err != nil {
+			return nil, err
+		}
+		f.add(name, gateway, cfg)
+	}
+	return f, nil
+}
+
+func (f *failoverGateway) add(name string, gateway paymenter, cfg breakerConfig) {
+	f.members = append(f.members, failoverMember{name: name, gateway: gateway, breaker: newCircuitBreaker(cfg)})
+}
+
+func (f *failoverGateway) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return f.first(ctx, func(gateway paymenter) (paymentResult, error) {
+		return gateway.pay(ctx, amount)
+	})
+}
+
+// first tries the members in order till one answers, errNotSupported from call skips the member without touching its breaker
+func (f *failoverGateway) first(ctx context.Context, call func(gateway paymenter) (paymentResult, error)) (paymentResult, error) {
+	var errs []error
+	for _, m := range f.members {
+		if !m.breaker.allow() {
+			continue //breaker open, skip this gateway
+		}
+		result, err := call(m.gateway)
+		if err == nil {
+			m.breaker.record(nil)
+			f.remember(result.transactionID, m.gateway)
+			return result, nil
+		}
+		if errors.Is(err, errNotSupported) {
+			m.breaker.release()
+			continue
+		}
+		if !isGatewayFault(err) {
+			//decline is the customer problem and cancel is the caller problem, neither is a success or failure of the gateway
+			//so a cancelled half-open probe must not close the breaker
+			m.breaker.release()
+			return result, err
+		}
+		m.breaker.record(err)
+		if !errors.Is(err, errGatewayUnreachable) {
+			return result, &paymentError{gateway: "failover", reason: errOutcomeUnknown, detail: fmt.Sprintf("%s: %v", m.name, err)}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+	}
+	if len(errs) == 0 {
+		return paymentResult{}, &paymentError{gateway: "failover", reason: errGatewayUnavailable, detail: errNoGatewayAvailable.Error()}
+	}
+	return paymentResult{}, &paymentError{gateway: "failover", reason: errGatewayUnavailable, detail: errors.Join(errs...).Error()}
+}
+
+func (f *failoverGateway) remember(transactionID string, gateway paymenter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.owners == nil {
+		f.owners = map[string]paymenter{}
+	}
+	f.owners[transactionID] = gateway
+}
+
+func (f *failoverGateway) owner(transactionID string) (paymenter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	gateway, ok := f.owners[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("failover: %w: %s", errTransactionNotFound, transactionID)
+	}
+	return gateway, nil
+}
+
+// gatewayHealth is a copy of the breaker state, safe to give to dashboards
+type gatewayHealth struct {
+	name                string
+	state               breakerState
+	consecutiveFailures int
+	lastError           string
+	lastFailure         time.Time
+}
+
+func (f *failoverGateway) health() []gatewayHealth {
+	out := make([]gatewayHealth, 0, len(f.members))
+	for _, m := range f.members {
+		b := m.breaker
+		b.mu.Lock()
+		h := gatewayHealth{
+			name:                m.name,
+			state:               b.state,
+			consecutiveFailures: b.failures,
+			lastFailure:         b.lastFailure,
+		}
+		if b.lastErr != nil {
+			h.lastError = b.lastErr.Error()
+		}
+		b.mu.Unlock()
+		out = append(out, h)
+	}
+	return out
+}
+
+func failoverDemo(amount money.Money) {
+	fmt.Println("+++++FAILOVER+++++")
+	cfg := breakerConfig{failureThreshold: 2, coolDown: time.Minute, successThreshold: 1}
+	failover := &failoverGateway{}
+	failover.add("stripe", &fakePayment{err: errGatewayUnreachable}, cfg) //stripe is down, connection refused
+	razorpayStub := newRazorpayStub(stubOptions{})
+	defer razorpayStub.Close()
+	failover.add("razorpay", &razorpay{baseURL: razorpayStub.URL, keyID: "rzp_test_local", keySecret: "secret"}, cfg)
+
+	p := payment{gateway: failover}
+	for i := 0; i < 3; i++ {
+		result, err := p.makePayment(context.Background(), amount)
+		fmt.Println("paid via", result.gateway, "err:", err)
+	}
+	for _, h := range failover.health() {
+		fmt.Println(h.name, h.state, "failures:", h.consecutiveFailures, "last error:", h.lastError)
+	}
+}
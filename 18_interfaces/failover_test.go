@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+func TestCancelledHalfOpenProbeKeepsBreakerOpen(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	down := &fakePayment{err: errGatewayUnavailable}
+	f := &failoverGateway{}
+	f.add("down", down, breakerConfig{failureThreshold: 1, coolDown: time.Minute, successThreshold: 1})
+	breaker := f.members[0].breaker
+	breaker.now = func() time.Time { return now }
+
+	f.pay(context.Background(), money.MustParse("1.00", "INR")) //trips the breaker
+	now = now.Add(2 * time.Minute)                              //cool down over -> next call is the half-open probe
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.pay(ctx, money.MustParse("1.00", "INR"))
+
+	if breaker.state == breakerClosed {
+		t.Fatal("cancelled probe closed the breaker")
+	}
+	if !breaker.allow() {
+		t.Error("probe slot was not released after cancelled call")
+	}
+}
+
+func TestWrappersForwardCapabilities(t *testing.T) {
+	ctx := context.Background()
+	down := &fakePayment{err: errGatewayUnreachable}
+	up := &fakePayment{}
+	chain := &failoverGateway{}
+	chain.add("down", retryingGateway{gateway: down, policy: testRetryPolicy(1)}, defaultBreakerConfig())
+	chain.add("up", retryingGateway{gateway: up, policy: testRetryPolicy(2)}, defaultBreakerConfig())
+	p := payment{gateway: chain}
+
+	auth, err := p.authorize(ctx, money.MustParse("100.00", "INR"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if _, err := p.capture(ctx, auth.transactionID, money.MustParse("100.00", "INR")); err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	refund, err := p.refund(ctx, auth.transactionID, money.MustParse("40.00", "INR"))
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refund.remaining != money.MustParse("60.00", "INR") {
+		t.Errorf("remaining = %v, want INR 60.00", refund.remaining)
+	}
+	if down.calls != 1 {
+		t.Errorf("capture/refund went to the wrong gateway, down got %d calls", down.calls)
+	}
+
+	if _, err := p.void(ctx, "unknown"); !errors.Is(err, errTransactionNotFound) {
+		t.Errorf("void unknown = %v, want errTransactionNotFound", err)
+	}
+}
+
+func TestFailoverOnlyWhenNotSent(t *testing.T) {
+	tests := []struct {
+		name       string
+		primary    paymenter
+		wantErr    error //nil -> secondary takes the payment
+		wantSecond int
+	}{
+		{"connection refused fails over", &fakePayment{err: errGatewayUnreachable}, nil, 1},
+		{"timeout or 5xx is unknown", &fakePayment{err: errGatewayUnavailable}, errOutcomeUnknown, 0},
+		//first attempt timed out, retry could not connect: the first one may still have charged
+		{"unreachable after a sent attempt is unknown", retryingGateway{
+			gateway: &sequencePayment{errs: []error{errGatewayUnavailable, errGatewayUnreachable}},
+			policy:  testRetryPolicy(2),
+		}, errOutcomeUnknown, 0},
+		{"declined is returned as is", &fakePayment{err: errDeclined}, errDeclined, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secondary := &fakePayment{}
+			f := &failoverGateway{}
+			f.add("primary", tt.primary, defaultBreakerConfig())
+			f.add("secondary", secondary, defaultBreakerConfig())
+
+			_, err := f.pay(context.Background(), money.MustParse("10.00", "INR"))
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("pay: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errOutcomeUnknown) && errors.Is(err, errGatewayUnavailable) {
+				t.Error("unknown outcome is retryable, a retry could pay again")
+			}
+			if secondary.calls != tt.wantSecond {
+				t.Errorf("secondary got %d calls, want %d", secondary.calls, tt.wantSecond)
+			}
+		})
+	}
+}
+
+// sequencePayment fails with errs in order, one per call
+type sequencePayment struct {
+	errs  []error
+	calls int
+}
+
+func (s *sequencePayment) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	err := s.errs[min(s.calls, len(s.errs)-1)]
+	s.calls++
+	return paymentResult{}, &paymentError{gateway: "sequencePayment", reason: err}
+}
+
+func TestClosedServerIsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close() //port is free now -> connection refused
+	gw := &stripe{baseURL: server.URL, secretKey: "sk_test"}
+	if _, err := gw.pay(context.Background(), money.MustParse("10.00", "INR")); !errors.Is(err, errGatewayUnreachable) {
+		t.Errorf("err = %v, want errGatewayUnreachable", err)
+	}
+}
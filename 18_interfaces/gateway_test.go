@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+// every gateway client against its stub server, nothing leaves the machine
+type stubbedGateway struct {
+	name  string
+	start func(opts stubOptions, withKeys bool) (gateway paymenter, close func())
+}
+
+var stubbedGateways = []stubbedGateway{
+	{"razorpay", func(opts stubOptions, withKeys bool) (paymenter, func()) {
+		server := newRazorpayStub(opts)
+		gw := &razorpay{baseURL: server.URL}
+		if withKeys {
+			gw.keyID, gw.keySecret = "rzp_test", "secret"
+		}
+		return gw, server.Close
+	}},
+	{"stripe", func(opts stubOptions, withKeys bool) (paymenter, func()) {
+		server := newStripeStub(opts)
+		gw := &stripe{baseURL: server.URL}
+		if withKeys {
+			gw.secretKey = "sk_test"
+		}
+		return gw, server.Close
+	}},
+}
+
+func TestGatewayStubs(t *testing.T) {
+	amount := money.MustParse("499.00", "INR")
+	for _, g := range stubbedGateways {
+		t.Run(g.name+"/success", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{}, true)
+			defer stop()
+			result, err := gw.pay(context.Background(), amount)
+			if err != nil {
+				t.Fatalf("pay: %v", err)
+			}
+			if result.status != paymentSucceeded || result.transactionID == "" {
+				t.Errorf("result = %+v, want succeeded with id", result)
+			}
+			refund, err := payment{gateway: gw}.refund(context.Background(), result.transactionID, money.MustParse("99.00", "INR"))
+			if err != nil {
+				t.Fatalf("refund: %v", err)
+			}
+			if refund.remaining != money.MustParse("400.00", "INR") {
+				t.Errorf("remaining = %v, want INR 400.00", refund.remaining)
+			}
+		})
+
+		t.Run(g.name+"/4xx declined", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{failCode: "card_declined"}, true)
+			defer stop()
+			_, err := gw.pay(context.Background(), amount)
+			if !errors.Is(err, errDeclined) {
+				t.Errorf("err = %v, want errDeclined", err)
+			}
+			if isRetryablePaymentError(err) {
+				t.Error("decline must not be retryable")
+			}
+		})
+
+		t.Run(g.name+"/4xx unknown transaction", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{}, true)
+			defer stop()
+			_, err := payment{gateway: gw}.capture(context.Background(), "does/not/exist", amount)
+			if !errors.Is(err, errTransactionNotFound) {
+				t.Errorf("err = %v, want errTransactionNotFound", err)
+			}
+		})
+
+		t.Run(g.name+"/4xx missing credentials", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{}, false)
+			defer stop()
+			_, err := gw.pay(context.Background(), amount)
+			var pe *paymentError
+			if !errors.As(err, &pe) || isRetryablePaymentError(err) {
+				t.Errorf("err = %v, want non retryable paymentError", err)
+			}
+		})
+
+		t.Run(g.name+"/5xx", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{failCode: "server_error"}, true)
+			defer stop()
+			_, err := gw.pay(context.Background(), amount)
+			if !errors.Is(err, errGatewayUnavailable) {
+				t.Errorf("err = %v, want errGatewayUnavailable", err)
+			}
+		})
+
+		t.Run(g.name+"/timeout", func(t *testing.T) {
+			gw, stop := g.start(stubOptions{latency: time.Second}, true)
+			defer stop()
+
+			//caller deadline -> context error, not the gateway fault
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := gw.pay(ctx, amount); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ctx timeout err = %v, want context.DeadlineExceeded", err)
+			}
+
+			//client timeout -> gateway too slow -> retryable
+			client := &http.Client{Timeout: 20 * time.Millisecond}
+			switch gw := gw.(type) {
+			case *razorpay:
+				gw.client = client
+			case *stripe:
+				gw.client = client
+			}
+			if _, err := gw.pay(context.Background(), amount); !errors.Is(err, errGatewayUnavailable) {
+				t.Errorf("client timeout err = %v, want errGatewayUnavailable", err)
+			}
+		})
+	}
+}
+
+func TestStripeVoidReleasesAuthorization(t *testing.T) {
+	server := newStripeStub(stubOptions{})
+	defer server.Close()
+	p := payment{gateway: &stripe{baseURL: server.URL, secretKey: "sk_test"}}
+	auth, err := p.authorize(context.Background(), money.MustParse("10.00", "INR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.void(context.Background(), auth.transactionID); err != nil {
+		t.Fatalf("void: %v", err)
+	}
+	if _, err := p.capture(context.Background(), auth.transactionID, money.MustParse("10.00", "INR")); !errors.Is(err, errNotAuthorized) {
+		t.Errorf("capture after void err = %v, want errNotAuthorized", err)
+	}
+}
+
+func TestRegistryNeedsGatewayKeys(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "")
+	t.Setenv("STRIPE_BASE_URL", "http://127.0.0.1:1")
+	if _, err := newPayment("stripe", gatewayConfig{}); !errors.Is(err, errMissingGatewayConfig) {
+		t.Errorf("err = %v, want errMissingGatewayConfig", err)
+	}
+	if _, err := newPayment("stripe", gatewayConfig{"stripe.secret_key": "sk_test"}); err != nil {
+		t.Errorf("with key from config: %v", err)
+	}
+}
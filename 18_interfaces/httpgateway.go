@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"learngo/internal/money"
+)
+
+/*
+razorpay and stripe now talk HTTP to a base URL instead of printing
+they speak a STUB PROTOCOL: auth scheme, body encoding, paths and error shapes follow the providers
+(razorpay -> basic auth + JSON, stripe -> bearer key + form encoded body) but the payloads are cut down,
+real providers need card tokens / checkout which this lesson does not have
+so they are meant for the stub servers in stubs.go, base URL and keys come from config (registry.go), there is no live default
+
+both providers send errors in their own JSON shape but the error codes are the same list below
+client maps the code back to our sentinel errors so errors.Is(err, errDeclined) still works
+*/
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// error code on the wire <-> our error, used by the stubs to send and by the clients to read
+var gatewayErrorCodes = map[string]error{
+	"card_declined":              errDeclined,
+	"insufficient_funds":         errInsufficientFunds,
+	"server_error":               errGatewayUnavailable,
+	"transaction_not_found":      errTransactionNotFound,
+	"invalid_state":              errNotAuthorized,
+	"capture_exceeds_authorized": errCaptureExceedsAuth,
+	"refund_exceeds_captured":    errRefundExceedsCaptured,
+}
+
+func gatewayErrorCode(err error) string {
+	for code, target := range gatewayErrorCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return "invalid_request"
+}
+
+// decodes provider specific error body into (code, message)
+type errorDecoder func(body []byte) (code string, message string)
+
+// encodes the request body, returns body and its content type
+type bodyEncoder func(in any) ([]byte, string, error)
+
+type gatewayAPI struct {
+	name        string
+	baseURL     string
+	auth        func(req *http.Request) //sets the provider auth header
+	encode      bodyEncoder             //nil -> JSON
+	client      *http.Client
+	decodeError errorDecoder
+}
+
+func encodeJSON(in any) ([]byte, string, error) {
+	body, err := json.Marshal(in)
+	return body, "application/json", err
+}
+
+/*
+a POST which timed out or got a 5xx/429 may still have been done by the gateway, only the answer was lost
+so every attempt of one operation sends the same Idempotency-Key header,
+gateway (and our stubs) answer a key it has seen with the first reply instead of charging again
+retryingGateway puts the key in ctx once before the first attempt, makePaymentOnce uses the checkout key
+*/
+type requestKeyContextKey struct{}
+
+func withRequestKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, requestKeyContextKey{}, key)
+}
+
+func requestKeyFrom(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(requestKeyContextKey{}).(string)
+	return key, ok && key != ""
+}
+
+// escapedPath builds "/v1/payments/<id>/capture" with the id escaped, so an id like "../x" can not change the path
+func escapedPath(format string, id string) string {
+	return fmt.Sprintf(format, url.PathEscape(id))
+}
+
+// post sends in and decodes the 2xx JSON response into out
+func (g gatewayAPI) post(ctx context.Context, path string, in any, out any) error {
+	if g.baseURL == "" {
+		return fmt.Errorf("%s: %w: base url", g.name, errMissingGatewayConfig)
+	}
+	encode := g.encode
+	if encode == nil {
+		encode = encodeJSON
+	}
+	body, contentType, err := encode(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(g.baseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if key, ok := requestKeyFrom(ctx); ok {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if g.auth != nil {
+		g.auth(req)
+	}
+
+	client := g.client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil { //cancelled by caller, not the gateway fault
+			return ctx.Err()
+		}
+		reason := errGatewayUnavailable
+		if notSent(err) {
+			reason = errGatewayUnreachable
+		}
+		return &paymentError{gateway: g.name, reason: reason, detail: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &paymentError{gateway: g.name, reason: errGatewayUnavailable, detail: err.Error()}
+	}
+	if resp.StatusCode >= 300 {
+		return g.toError(resp.StatusCode, respBody)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &paymentError{gateway: g.name, reason: errGatewayUnavailable, detail: "bad response: " + err.Error()}
+	}
+	return nil
+}
+
+// notSent tells if err came while connecting, before any byte of the request was written
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+func (g gatewayAPI) toError(status int, body []byte) error {
+	code, message := g.decodeError(body)
+	if reason, ok := gatewayErrorCodes[code]; ok {
+		return &paymentError{gateway: g.name, reason: reason, detail: message}
+	}
+	if status >= 500 || status == http.StatusTooManyRequests {
+		return &paymentError{gateway: g.name, reason: errGatewayUnavailable, detail: fmt.Sprintf("http %d %s", status, message)}
+	}
+	return &paymentError{gateway: g.name, reason: fmt.Errorf("%s (http %d)", code, status), detail: message}
+}
+
+// amount on the wire is minor units + lower case currency, same as the providers do
+func moneyFromWire(minor int64, currency string) money.Money {
+	return money.FromMinor(minor, currency) //FromMinor upper cases the currency
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"learngo/internal/jsonl"
+	"learngo/internal/money"
+)
+
+/*
+double click on "pay" = two makePayment calls = customer charged two times
+so client sends an idempotency key (same key for the same checkout) and we remember the result per key
+second call with the same key gets the saved result back and the gateway is not called again
+only successful payments are saved, failed one did not charge anything so calling again is safe
+store is an interface so we can keep keys in memory (default) or in a file
+*/
+
+var errIdempotencyKeyReused = errors.New("idempotency key already used for a different amount")
+
+// exported fields with json tags because the file store writes it as JSON
+type idempotencyRecord struct {
+	Key           string    `json:"key"`
+	TransactionID string    `json:"transactionId"`
+	Gateway       string    `json:"gateway"`
+	Status        string    `json:"status"`
+	AmountMinor   int64     `json:"amountMinor"`
+	Currency      string    `json:"currency"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (r idempotencyRecord) result() paymentResult {
+	return paymentResult{transactionID: r.TransactionID, gateway: r.Gateway, status: paymentStatus(r.Status)}
+}
+
+type idempotencyStore interface {
+	lock(key string) (unlock func()) //so two calls with same key at the same time dont both reach the gateway
+	get(key string) (idempotencyRecord, bool, error)
+	save(record idempotencyRecord) error
+}
+
+// one mutex per key, entry is removed when nobody is waiting on it
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+func (k *keyedLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.waiters++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// in memory store, keys are forgotten after ttl
+type memoryIdempotencyStore struct {
+	keyedLocks
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	records map[string]idempotencyRecord
+}
+
+func newMemoryIdempotencyStore(ttl time.Duration) *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{ttl: ttl, now: time.Now, records: map[string]idempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) expired(r idempotencyRecord) bool {
+	return s.ttl > 0 && s.now().Sub(r.CreatedAt) > s.ttl
+}
+
+func (s *memoryIdempotencyStore) get(key string) (idempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key]
+	if ok && s.expired(r) {
+		delete(s.records, key)
+		return idempotencyRecord{}, false, nil
+	}
+	return r, ok, nil
+}
+
+// save stamps CreatedAt from the store clock, so ttl and the record use the same time
+func (s *memoryIdempotencyStore) save(record idempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = s.now()
+	}
+	s.records[record.Key] = record
+	return nil
+}
+
+// sweep drops every expired key, get only cleans the keys somebody asks for again
+func (s *memoryIdempotencyStore) sweep() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for key, r := range s.records {
+		if s.expired(r) {
+			delete(s.records, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+type sweeper interface {
+	sweep() (removed int, err error)
+}
+
+// startSweeper sweeps the store every interval till stop is called, sweep errors go to onError (nil -> dropped)
+func startSweeper(store sweeper, interval time.Duration, onError func(error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := store.sweep(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+/*
+file store keeps every record as one JSON line in the file (append only)
+on start we read the file back into memory so keys survive a restart, expired keys are skipped
+sweep also compacts the file, so it only has the live keys and does not grow forever
+lock only works inside one process, two processes on the same file are not protected
+*/
+type fileIdempotencyStore struct {
+	*memoryIdempotencyStore
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openFileIdempotencyStore(path string, ttl time.Duration) (*fileIdempotencyStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening idempotency store: %w", err)
+	}
+	mem := newMemoryIdempotencyStore(ttl)
+	lines := 0
+	err = jsonl.Replay(f, func(line []byte) error {
+		var r idempotencyRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		lines++
+		if !mem.expired(r) {
+			mem.records[r.Key] = r
+		}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading idempotency store %s: %w", path, err)
+	}
+	s := &fileIdempotencyStore{memoryIdempotencyStore: mem, path: path, file: f}
+	if lines > len(mem.records) { //some keys expired while we were down
+		if err := s.compact(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *fileIdempotencyStore) save(record idempotencyRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = s.now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := jsonl.Append(s.file, line); err != nil {
+		return fmt.Errorf("writing idempotency store: %w", err)
+	}
+	return s.memoryIdempotencyStore.save(record)
+}
+
+// keys are already gone from memory when compact fails, the file still has them and is compacted on the next sweep or open
+func (s *fileIdempotencyStore) sweep() (int, error) {
+	removed, _ := s.memoryIdempotencyStore.sweep()
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.compact()
+}
+
+// compact rewrites the file with only the keys in memory
+func (s *fileIdempotencyStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memoryIdempotencyStore.mu.Lock()
+	records := make([]idempotencyRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	s.memoryIdempotencyStore.mu.Unlock()
+
+	err := jsonl.Compact(s.path, func(w io.Writer) error {
+		for _, r := range records {
+			line, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := jsonl.Append(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compacting idempotency store: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopening idempotency store: %w", err)
+	}
+	s.file.Close()
+	s.file = f
+	return nil
+}
+
+func (s *fileIdempotencyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// makePaymentOnce is makePayment with an idempotency key, without store it is same as makePayment
+func (p payment) makePaymentOnce(ctx context.Context, key string, amount money.Money) (paymentResult, error) {
+	if p.idempotency == nil || key == "" {
+		return p.makePayment(ctx, amount)
+	}
+
+	unlock := p.idempotency.lock(key)
+	defer unlock()
+
+	saved, found, err := p.idempotency.get(key)
+	if err != nil {
+		return paymentResult{}, err
+	}
+	if found {
+		if saved.AmountMinor != amount.Minor() || saved.Currency != amount.Currency() {
+			return paymentResult{}, fmt.Errorf("%w: key %q", errIdempotencyKeyReused, key)
+		}
+		return saved.result(), nil //same request again, give back the first result
+	}
+
+	result, err := p.makePayment(withRequestKey(ctx, key), amount) //gateway sees the same key, even after our restart
+	if err != nil {
+		return result, err
+	}
+	record := idempotencyRecord{
+		Key:           key,
+		TransactionID: result.transactionID,
+		Gateway:       result.gateway,
+		Status:        string(result.status),
+		AmountMinor:   amount.Minor(),
+		Currency:      amount.Currency(), //CreatedAt is stamped by the store with its own clock
+	}
+	if err := p.idempotency.save(record); err != nil {
+		return result, fmt.Errorf("payment done but idempotency key not saved: %w", err)
+	}
+	return result, nil
+}
+
+func idempotencyDemo(amount money.Money) {
+	fmt.Println("+++++IDEMPOTENCY+++++")
+	store := newMemoryIdempotencyStore(24 * time.Hour)
+	stopSweeper := startSweeper(store, time.Hour, func(err error) { fmt.Println("idempotency sweep:", err) }) //expired keys go away even if nobody asks for them again
+	defer stopSweeper()
+	p := payment{gateway: &fakePayment{}, idempotency: store}
+
+	//double click -> two calls with the same key, gateway is called only once
+	first, _ := p.makePaymentOnce(context.Background(), "checkout-42", amount)
+	second, _ := p.makePaymentOnce(context.Background(), "checkout-42", amount)
+	fmt.Println("first:", first.transactionID, "second:", second.transactionID)
+
+	double, _ := amount.Multiply(2)
+	if _, err := p.makePaymentOnce(context.Background(), "checkout-42", double); err != nil {
+		fmt.Println("error:", err)
+	}
+}
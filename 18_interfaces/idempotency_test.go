@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStoreSweep(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	store := newMemoryIdempotencyStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	store.save(idempotencyRecord{Key: "old"})
+	now = now.Add(30 * time.Minute)
+	store.save(idempotencyRecord{Key: "new"})
+	now = now.Add(45 * time.Minute) //old is 75 min old, new is 45 min old
+
+	if removed, err := store.sweep(); removed != 1 || err != nil {
+		t.Fatalf("sweep removed %d, %v, want 1", removed, err)
+	}
+	if _, ok := store.records["old"]; ok {
+		t.Error("expired key still in memory")
+	}
+	if r := store.records["new"]; !r.CreatedAt.Equal(now.Add(-45 * time.Minute)) {
+		t.Errorf("CreatedAt = %v, want store clock time", r.CreatedAt)
+	}
+}
+
+func TestFileIdempotencyStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.jsonl")
+	store, err := openFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.save(idempotencyRecord{Key: "expired", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	store.save(idempotencyRecord{Key: "live"})
+	store.Close()
+
+	//crash in the middle of the next write
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"key":"torn","transac`)
+	f.Close()
+
+	store, err = openFileIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen with torn last line: %v", err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.get("live"); !ok {
+		t.Error("live key lost on reload")
+	}
+	if _, ok := store.records["expired"]; ok {
+		t.Error("expired key loaded back")
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "expired") || strings.Contains(string(data), "torn") {
+		t.Errorf("file not compacted: %s", data)
+	}
+}
+
+func TestFileIdempotencySweepReportsCompactionError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	store, err := openFileIdempotencyStore(filepath.Join(dir, "keys.jsonl"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.save(idempotencyRecord{Key: "expired", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	os.RemoveAll(dir) //compaction can not write its temp file any more
+
+	if removed, err := store.sweep(); removed != 1 || err == nil {
+		t.Fatalf("sweep = %d, %v, want 1 and the compaction error", removed, err)
+	}
+
+	store.save(idempotencyRecord{Key: "expired again", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	errs := make(chan error, 1)
+	stop := startSweeper(store, time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	defer stop()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "compacting idempotency store") {
+			t.Errorf("onError got %v, want the compaction error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("sweeper did not report the compaction error")
+	}
+}
@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"learngo/internal/money"
+)
 
 // use to making our code scalable and organized
 /*
@@ -25,46 +33,137 @@ so we are doing dependency invesion by using interface mean our main code is not
 */
 
 type paymenter interface {
-	pay(amount float32)
+	pay(ctx context.Context, amount money.Money) (paymentResult, error) //ctx so caller can cancel or put a timeout on slow gateway
 }
 type payment struct {
-	gateway paymenter //now the gateway of the paymenter type
+	gateway     paymenter        //now the gateway of the paymenter type
+	idempotency idempotencyStore //optional, used by makePaymentOnce
 }
 
 // open close principle not following because we need to modify the code if we go from to stripe to razorpay
-func (p payment) makePayment(amount float32) {
+func (p payment) makePayment(ctx context.Context, amount money.Money) (paymentResult, error) {
 	// razorpayPaymentGW := razorpay{}
 	// razorpayPaymentGW.pay(amount)
 	// 	stripePayment := stripe{}
 	// 	stripePayment.pay(amount)
 
 	//now we are following the open close rule because we just need to extend the payment struct to tell which one to use
-	p.gateway.pay(amount)
+	if err := ctx.Err(); err != nil { //already cancelled, dont even call the gateway
+		return paymentResult{}, err
+	}
+	if !amount.IsPositive() {
+		return paymentResult{}, fmt.Errorf("invalid payment amount %v", amount)
+	}
+	return p.gateway.pay(ctx, amount)
 }
 
-type razorpay struct{}
+// razorpay and stripe live in razorpay.go and stripe.go, they talk HTTP to the provider now
 
-func (r razorpay) pay(amount float32) {
-	fmt.Println("payment processed by razorpay... ", amount)
-}
+// unit testing, set err to make the fake gateway fail like a real one would
+// with failFirst > 0 only the first failFirst calls fail, then it starts working (flaky gateway)
+type fakePayment struct {
+	err       error
+	failFirst int
 
-type stripe struct{}
-
-func (s stripe) pay(amount float32) {
-	fmt.Println("payment processed by stripe...", amount)
+	mu     sync.Mutex
+	calls  int
+	ledger txLedger
 }
 
-// unit testing
-type fakePayment struct{}
+func init() {
+	registerGateway("fakePayment", func(gatewayConfig) (paymenter, error) { return &fakePayment{}, nil })
+}
 
-func (fp fakePayment) pay(amount float32) {
+// pointer reciever because we count the calls
+func (fp *fakePayment) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	if err := ctx.Err(); err != nil {
+		return paymentResult{}, err
+	}
 	fmt.Println("making payment using fake gateway")
+	if err := fp.nextCall(); err != nil {
+		return paymentResult{gateway: "fakePayment", status: paymentFailed}, err
+	}
+	id := newTransactionID("fake")
+	fp.ledger.charge(id, amount)
+	return paymentResult{transactionID: id, gateway: "fakePayment", status: paymentSucceeded}, nil
+}
+
+// counts the call and tells if this one should fail
+func (fp *fakePayment) nextCall() error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.calls++
+	if fp.err != nil && (fp.failFirst == 0 || fp.calls <= fp.failFirst) {
+		return &paymentError{gateway: "fakePayment", reason: fp.err}
+	}
+	return nil
 }
 func main() {
-	// newStripe := stripe{}
-	fakePW := fakePayment{}
-	newPayment := payment{
-		gateway: fakePW,
+	// newStripe := &stripe{}
+	// fakePW := &fakePayment{}
+	// newPayment := payment{
+	// 	gateway: fakePW,
+	// }
+
+	//gateway comes from config now -> PAYMENT_GATEWAY=stripe go run .
+	myPayment, err := newPaymentFromConfig()
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	price := money.MustParse("33.33", "INR")
+	total, err := price.Multiply(3) //99.99 exactly, with float32 we would get 99.98999
+	if err != nil {
+		fmt.Println("error:", err)
+		return
 	}
-	newPayment.makePayment(100)
+	fmt.Println("order total:", total)
+
+	result, err := myPayment.makePayment(context.Background(), total)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("paid:", result.transactionID, "via", result.gateway, result.status)
+
+	//declined card, caller can check the reason with errors.Is
+	declined := payment{gateway: &fakePayment{err: errDeclined}}
+	if _, err := declined.makePayment(context.Background(), total); errors.Is(err, errDeclined) {
+		fmt.Println("card declined:", err)
+	}
+
+	//cancelled context -> gateway is never called
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := myPayment.makePayment(ctx, total); err != nil {
+		fmt.Println("error:", err)
+	}
+
+	//split the bill between 3 friends, no paisa lost
+	shares, err := money.MustParse("100.00", "INR").Allocate(1, 1, 1)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println("split:", shares)
+
+	//"--5" was read as 5.00 once, now every extra sign is an error
+	if _, err := money.Parse("--5", "INR"); err != nil {
+		fmt.Println("error:", err)
+	}
+	if _, err := total.Multiply(math.MaxInt64); errors.Is(err, money.ErrOverflow) {
+		fmt.Println("error:", err)
+	}
+
+	//adding rupees to dollars is an error, not a silent wrong number
+	if _, err := total.Add(money.MustParse("1.00", "USD")); err != nil {
+		fmt.Println("error:", err)
+	}
+
+	retryDemo(total)
+	failoverDemo(total)
+	idempotencyDemo(total)
+	refundDemo(total)
+	stubDemo(total)
+	webhookDemo()
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"learngo/internal/money"
+)
+
+/*
+razorpay client, stub protocol (see httpgateway.go): JSON bodies to /v1/payments, basic auth with key id + key secret
+razorpay has no void, an authorized payment which is never captured is released by razorpay itself
+so razorpay is not a voider, payment.void gives errNotSupported
+*/
+type razorpay struct {
+	baseURL   string
+	keyID     string
+	keySecret string
+	client    *http.Client //nil -> defaultHTTPClient
+}
+
+func init() {
+	registerGateway("razorpay", func(cfg gatewayConfig) (paymenter, error) {
+		v, err := cfg.require("razorpay.base_url", "razorpay.key_id", "razorpay.key_secret")
+		if err != nil {
+			return nil, err
+		}
+		return &razorpay{baseURL: v["razorpay.base_url"], keyID: v["razorpay.key_id"], keySecret: v["razorpay.key_secret"]}, nil
+	})
+}
+
+type razorpayPaymentRequest struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Capture  bool   `json:"capture"`
+}
+
+type razorpayPayment struct {
+	ID       string `json:"id"`
+	Entity   string `json:"entity"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"` //authorized, captured, voided, refunded
+}
+
+// used by capture and refund, both just need the amount
+type razorpayAmountRequest struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type razorpayRefund struct {
+	ID               string `json:"id"`
+	Entity           string `json:"entity"`
+	PaymentID        string `json:"payment_id"`
+	Amount           int64  `json:"amount"`
+	Currency         string `json:"currency"`
+	Status           string `json:"status"`
+	AmountRefundable int64  `json:"amount_refundable"`
+}
+
+type razorpayErrorBody struct {
+	Error struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+		Reason      string `json:"reason"`
+	} `json:"error"`
+}
+
+func decodeRazorpayError(body []byte) (string, string) {
+	var e razorpayErrorBody
+	if err := json.Unmarshal(body, &e); err != nil {
+		return "", strings.TrimSpace(string(body))
+	}
+	return e.Error.Reason, e.Error.Description
+}
+
+func (r *razorpay) api() gatewayAPI {
+	return gatewayAPI{
+		name:        "razorpay",
+		baseURL:     r.baseURL,
+		auth:        func(req *http.Request) { req.SetBasicAuth(r.keyID, r.keySecret) },
+		client:      r.client,
+		decodeError: decodeRazorpayError,
+	}
+}
+
+func (p razorpayPayment) result() paymentResult {
+	status := paymentStatus(p.Status)
+	if p.Status == "captured" {
+		status = paymentSucceeded
+	}
+	return paymentResult{transactionID: p.ID, gateway: "razorpay", status: status}
+}
+
+func (r *razorpay) createPayment(ctx context.Context, amount money.Money, capture bool) (paymentResult, error) {
+	var resp razorpayPayment
+	req := razorpayPaymentRequest{Amount: amount.Minor(), Currency: amount.Currency(), Capture: capture}
+	if err := r.api().post(ctx, "/v1/payments", req, &resp); err != nil {
+		return paymentResult{gateway: "razorpay", status: paymentFailed}, err
+	}
+	return resp.result(), nil
+}
+
+func (r *razorpay) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return r.createPayment(ctx, amount, true)
+}
+
+func (r *razorpay) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return r.createPayment(ctx, amount, false)
+}
+
+func (r *razorpay) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	var resp razorpayPayment
+	req := razorpayAmountRequest{Amount: amount.Minor(), Currency: amount.Currency()}
+	if err := r.api().post(ctx, escapedPath("/v1/payments/%s/capture", transactionID), req, &resp); err != nil {
+		return paymentResult{}, err
+	}
+	return resp.result(), nil
+}
+
+func (r *razorpay) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	var resp razorpayRefund
+	req := razorpayAmountRequest{Amount: amount.Minor(), Currency: amount.Currency()}
+	if err := r.api().post(ctx, escapedPath("/v1/payments/%s/refund", transactionID), req, &resp); err != nil {
+		return refundResult{}, err
+	}
+	return refundResult{
+		refundID:      resp.ID,
+		transactionID: resp.PaymentID,
+		amount:        moneyFromWire(resp.Amount, resp.Currency),
+		remaining:     moneyFromWire(resp.AmountRefundable, resp.Currency),
+		status:        refundStatus(resp.AmountRefundable),
+	}, nil
+}
+
+func refundStatus(remaining int64) paymentStatus {
+	if remaining == 0 {
+		return paymentRefunded
+	}
+	return paymentPartiallyRefunded
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+main was hard wiring payment{gateway: fakePayment{}}, to switch gateway we had to change code and recompile
+now every gateway registers itself by name in init() (init runs automatically before main)
+and payment is built from config -> PAYMENT_GATEWAY env var, or "gateway=<name>" line in the file pointed by PAYMENT_CONFIG
+same file keeps the gateway settings like "razorpay.key_id=...", env var RAZORPAY_KEY_ID wins over it
+factory gets the config and fails when something it needs is missing, so a missing api key is an error at startup not at the first payment
+*/
+
+const (
+	gatewayEnv       = "PAYMENT_GATEWAY"
+	gatewayConfigEnv = "PAYMENT_CONFIG"
+	defaultGateway   = "fakePayment"
+)
+
+var errMissingGatewayConfig = errors.New("missing payment gateway config")
+
+// gatewayConfig is key=value settings from the PAYMENT_CONFIG file
+type gatewayConfig map[string]string
+
+// value looks at env first ("razorpay.key_id" -> RAZORPAY_KEY_ID), then the file
+func (c gatewayConfig) value(key string) string {
+	env := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+		return v
+	}
+	return c[key]
+}
+
+// require is value which must be set, error tells both places it can come from
+func (c gatewayConfig) require(keys ...string) (map[string]string, error) {
+	values := map[string]string{}
+	var missing []string
+	for _, key := range keys {
+		v := c.value(key)
+		if v == "" {
+			missing = append(missing, fmt.Sprintf("%s (%s)", key, strings.ToUpper(strings.ReplaceAll(key, ".", "_"))))
+		}
+		values[key] = v
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errMissingGatewayConfig, strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// factory instead of instance so every payment gets its own fresh gateway
+var gateways = map[string]func(cfg gatewayConfig) (paymenter, error){}
+
+func registerGateway(name string, factory func(cfg gatewayConfig) (paymenter, error)) {
+	if _, exists := gateways[name]; exists {
+		panic("payment gateway registered twice: " + name) // programming mistake, fail at startup
+	}
+	gateways[name] = factory
+}
+
+func knownGateways() []string {
+	names := make([]string, 0, len(gateways))
+	for name := range gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names) //map order is random, sort for stable error messages
+	return names
+}
+
+type unknownGatewayError struct {
+	name  string
+	known []string
+}
+
+func (e *unknownGatewayError) Error() string {
+	return fmt.Sprintf("unknown payment gateway %q, known gateways: %s", e.name, strings.Join(e.known, ", "))
+}
+
+func buildGateway(name string, cfg gatewayConfig) (paymenter, error) {
+	factory, ok := gateways[name]
+	if !ok {
+		return nil, &unknownGatewayError{name: name, known: knownGateways()}
+	}
+	gateway, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("gateway %s: %w", name, err)
+	}
+	return gateway, nil
+}
+
+func newPayment(gatewayName string, cfg gatewayConfig) (payment, error) {
+	gateway, err := buildGateway(gatewayName, cfg)
+	if err != nil {
+		return payment{}, err
+	}
+	return payment{gateway: gateway}, nil
+}
+
+// loadGatewayConfig reads the PAYMENT_CONFIG file, no file is an empty config
+func loadGatewayConfig() (gatewayConfig, error) {
+	cfg := gatewayConfig{}
+	path := os.Getenv(gatewayConfigEnv)
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading payment config: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, found := strings.Cut(line, "="); found {
+			cfg[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading payment config: %w", err)
+	}
+	return cfg, nil
+}
+
+// env var wins over the config file, if nothing is set we use the fake gateway
+func gatewayNameFromConfig(cfg gatewayConfig) string {
+	if name := strings.TrimSpace(os.Getenv(gatewayEnv)); name != "" {
+		return name
+	}
+	if name := cfg["gateway"]; name != "" {
+		return name
+	}
+	return defaultGateway
+}
+
+func newPaymentFromConfig() (payment, error) {
+	cfg, err := loadGatewayConfig()
+	if err != nil {
+		return payment{}, err
+	}
+	return newPayment(gatewayNameFromConfig(cfg), cfg)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+/*
+pay used to return nothing so makePayment could not know if money was taken or not
+now every gateway returns a paymentResult and an error
+errors are typed -> paymentError keeps the gateway name and wraps one of the sentinel errors below
+so caller can do errors.Is(err, errDeclined) without caring which gateway failed
+*/
+
+var (
+	errDeclined           = errors.New("payment declined")
+	errInsufficientFunds  = errors.New("insufficient funds")
+	errGatewayUnavailable = errors.New("gateway unavailable")
+	//connection refused or DNS failed, request never left our side so nothing was charged
+	errGatewayUnreachable = fmt.Errorf("%w: request not sent", errGatewayUnavailable)
+	//request was sent but no answer came back (timeout, 5xx), the gateway may or may not have charged
+	errOutcomeUnknown = errors.New("payment outcome unknown, check with the gateway before paying again")
+)
+
+type paymentError struct {
+	gateway string
+	reason  error //one of the sentinel errors
+	detail  string
+}
+
+func (e *paymentError) Error() string {
+	if e.detail == "" {
+		return fmt.Sprintf("%s: %v", e.gateway, e.reason)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.gateway, e.reason, e.detail)
+}
+
+// Unwrap lets errors.Is and errors.As look inside paymentError
+func (e *paymentError) Unwrap() error {
+	return e.reason
+}
+
+type paymentStatus string
+
+const (
+	paymentSucceeded paymentStatus = "succeeded"
+	paymentFailed    paymentStatus = "failed"
+)
+
+type paymentResult struct {
+	transactionID string
+	gateway       string
+	status        paymentStatus
+}
+
+// random id with the gateway prefix like rzp_1a2b3c4d5e6f7a8b
+func newTransactionID(prefix string) string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return prefix + "_" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"learngo/internal/money"
+)
+
+/*
+flaky gateway -> first call fails, second works, so we retry
+retryingGateway wraps any paymenter and itself is a paymenter (decorator), so payment does not know retries are happening
+delay grows exponentially base, 2*base, 4*base ... till maxDelay
+jitter takes a random part out of the delay so thousands of clients dont retry at the same moment
+only errors which the classifier says are retryable are retried, declined card will be declined again so no point
+a timeout does not tell if the gateway charged or not, so all attempts share one Idempotency-Key (httpgateway.go)
+and the gateway gives the first result back instead of charging again, wrapped gateways must honour that key
+*/
+
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64          //0 = no jitter, 1 = delay can be anything between 0 and full delay
+	retryable   func(error) bool //nil means isRetryablePaymentError
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxAttempts: 3,
+		baseDelay:   100 * time.Millisecond,
+		maxDelay:    2 * time.Second,
+		jitter:      0.5,
+		retryable:   isRetryablePaymentError,
+	}
+}
+
+// only gateway being down is temporary, declined and insufficient funds are final answers
+func isRetryablePaymentError(err error) bool {
+	return errors.Is(err, errGatewayUnavailable)
+}
+
+// delay before retry number attempt (attempt starts from 1 for the first retry)
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt; i++ {
+		if (p.maxDelay > 0 && delay >= p.maxDelay) || delay > math.MaxInt64/2 { //maxDelay 0 means no cap, still stop before int64 overflow
+			break
+		}
+		delay *= 2
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if p.jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.jitter * float64(delay))
+	}
+	return delay
+}
+
+// what happened in one attempt, given to onAttempt so caller can log or collect metrics
+type attemptOutcome struct {
+	attempt int
+	err     error
+	delay   time.Duration //wait before next attempt, 0 when we are not retrying
+}
+
+type retryingGateway struct {
+	gateway   paymenter
+	policy    retryPolicy
+	onAttempt func(attemptOutcome) //optional
+}
+
+func (r retryingGateway) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return retryCall(ctx, r, func(ctx context.Context) (paymentResult, error) {
+		return r.gateway.pay(ctx, amount)
+	})
+}
+
+// retryCall is the retry loop, generic so authorize/capture/void (capabilities.go) reuse it with their own result type
+func retryCall[T any](ctx context.Context, r retryingGateway, call func(ctx context.Context) (T, error)) (T, error) {
+	retryable := r.policy.retryable
+	if retryable == nil {
+		retryable = isRetryablePaymentError
+	}
+	maxAttempts := r.policy.maxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	if _, ok := requestKeyFrom(ctx); !ok {
+		ctx = withRequestKey(ctx, newTransactionID("idem")) //one key for every attempt of this call
+	}
+
+	var zero T
+	var lastErr error
+	sent := false //some attempt reached the gateway, so the call as a whole is not "not sent"
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		result, err := call(ctx)
+		outcome := attemptOutcome{attempt: attempt, err: err}
+		if err == nil || !retryable(err) || attempt == maxAttempts {
+			r.report(outcome)
+			if err != nil && sent && errors.Is(err, errGatewayUnreachable) {
+				return result, fmt.Errorf("payment failed after %d attempts: %w, an earlier attempt may have gone through: %v", attempt, errGatewayUnavailable, err)
+			}
+			if err != nil && attempt > 1 {
+				return result, fmt.Errorf("payment failed after %d attempts: %w", attempt, err)
+			}
+			return result, err
+		}
+		if !errors.Is(err, errGatewayUnreachable) {
+			sent = true
+		}
+
+		lastErr = err
+		outcome.delay = r.policy.backoff(attempt)
+		r.report(outcome)
+
+		timer := time.NewTimer(outcome.delay)
+		select {
+		case <-ctx.Done(): //caller gave up, stop waiting
+			timer.Stop()
+			return zero, fmt.Errorf("payment retry cancelled after %d attempts: %w", attempt, errors.Join(ctx.Err(), lastErr))
+		case <-timer.C:
+		}
+	}
+	return zero, lastErr //not reached, loop always returns
+}
+
+func (r retryingGateway) report(outcome attemptOutcome) {
+	if r.onAttempt != nil {
+		r.onAttempt(outcome)
+	}
+}
+
+func retryDemo(amount money.Money) {
+	fmt.Println("+++++RETRY+++++")
+	flaky := &fakePayment{err: errGatewayUnavailable, failFirst: 2} //fails 2 times then works
+	withRetry := payment{gateway: retryingGateway{
+		gateway: flaky,
+		policy:  defaultRetryPolicy(),
+		onAttempt: func(o attemptOutcome) {
+			fmt.Println("attempt", o.attempt, "err:", o.err, "next retry in:", o.delay.Round(time.Millisecond))
+		},
+	}}
+	result, err := withRetry.makePayment(context.Background(), amount)
+	fmt.Println("result:", result.transactionID, "err:", err)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"learngo/internal/money"
+)
+
+func testRetryPolicy(maxAttempts int) retryPolicy {
+	return retryPolicy{maxAttempts: maxAttempts, baseDelay: time.Millisecond, maxDelay: 10 * time.Millisecond}
+}
+
+func TestRetrySucceedsAfterFailures(t *testing.T) {
+	flaky := &fakePayment{err: errGatewayUnavailable, failFirst: 2}
+	var outcomes []attemptOutcome
+	gw := retryingGateway{gateway: flaky, policy: testRetryPolicy(3), onAttempt: func(o attemptOutcome) {
+		outcomes = append(outcomes, o)
+	}}
+
+	result, err := gw.pay(context.Background(), money.MustParse("10.00", "INR"))
+	if err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	if result.status != paymentSucceeded {
+		t.Errorf("status = %v, want succeeded", result.status)
+	}
+	if flaky.calls != 3 || len(outcomes) != 3 {
+		t.Errorf("calls = %d, outcomes = %d, want 3 and 3", flaky.calls, len(outcomes))
+	}
+	if outcomes[2].err != nil || outcomes[2].delay != 0 {
+		t.Errorf("last outcome = %+v, want success without delay", outcomes[2])
+	}
+}
+
+func TestRetryStopsOnNonRetryableError(t *testing.T) {
+	declined := &fakePayment{err: errDeclined}
+	gw := retryingGateway{gateway: declined, policy: testRetryPolicy(5)}
+
+	_, err := gw.pay(context.Background(), money.MustParse("10.00", "INR"))
+	if !errors.Is(err, errDeclined) {
+		t.Fatalf("err = %v, want errDeclined", err)
+	}
+	if declined.calls != 1 {
+		t.Errorf("calls = %d, want 1", declined.calls)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	down := &fakePayment{err: errGatewayUnavailable}
+	gw := retryingGateway{gateway: down, policy: testRetryPolicy(3)}
+
+	_, err := gw.pay(context.Background(), money.MustParse("10.00", "INR"))
+	if !errors.Is(err, errGatewayUnavailable) {
+		t.Fatalf("err = %v, want errGatewayUnavailable", err)
+	}
+	if down.calls != 3 {
+		t.Errorf("calls = %d, want 3", down.calls)
+	}
+}
+
+func TestRetryCancelledWhileSleeping(t *testing.T) {
+	down := &fakePayment{err: errGatewayUnavailable}
+	policy := retryPolicy{maxAttempts: 5, baseDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	gw := retryingGateway{gateway: down, policy: policy, onAttempt: func(o attemptOutcome) {
+		if o.delay > 0 {
+			cancel() //caller gives up while we wait an hour for the retry
+		}
+	}}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := gw.pay(ctx, money.MustParse("10.00", "INR"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) || !errors.Is(err, errGatewayUnavailable) {
+			t.Errorf("err = %v, want both context.Canceled and errGatewayUnavailable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pay kept sleeping after ctx was cancelled")
+	}
+	if down.calls != 1 {
+		t.Errorf("calls = %d, want 1", down.calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  retryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first retry", retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}, 1, 100 * time.Millisecond},
+		{"doubles", retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}, 3, 400 * time.Millisecond},
+		{"capped", retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}, 10, time.Second},
+		{"no cap keeps doubling", retryPolicy{baseDelay: 100 * time.Millisecond}, 5, 1600 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+
+	//no cap and many attempts, doubling must stop before int64 wraps to a negative delay
+	if got := (retryPolicy{baseDelay: time.Second}).backoff(200); got <= 0 {
+		t.Errorf("uncapped backoff(200) = %v, want positive", got)
+	}
+}
+
+// lossyProxy forwards to the stub, but the first reply is lost on the way back like a timeout after the charge
+type lossyProxy struct {
+	target string
+
+	mu        sync.Mutex
+	keys      []string
+	firstBody []byte
+}
+
+func (p *lossyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequest(r.Method, p.target+r.URL.Path, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header = r.Header.Clone()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	p.mu.Lock()
+	p.keys = append(p.keys, r.Header.Get("Idempotency-Key"))
+	first := len(p.keys) == 1
+	if first {
+		p.firstBody = body
+	}
+	p.mu.Unlock()
+	if first {
+		http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+		return
+	}
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+func TestRetryAfterLostReplyDoesNotChargeTwice(t *testing.T) {
+	gateways := []struct {
+		name    string
+		stub    func(stubOptions) *httptest.Server
+		gateway func(baseURL string) paymenter
+	}{
+		{"razorpay", newRazorpayStub, func(u string) paymenter { return &razorpay{baseURL: u, keyID: "rzp_test", keySecret: "secret"} }},
+		{"stripe", newStripeStub, func(u string) paymenter { return &stripe{baseURL: u, secretKey: "sk_test"} }},
+	}
+	for _, g := range gateways {
+		t.Run(g.name, func(t *testing.T) {
+			stub := g.stub(stubOptions{})
+			defer stub.Close()
+			proxy := &lossyProxy{target: stub.URL}
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			gw := retryingGateway{gateway: g.gateway(server.URL), policy: testRetryPolicy(3)}
+			result, err := gw.pay(context.Background(), money.MustParse("10.00", "INR"))
+			if err != nil {
+				t.Fatalf("pay: %v", err)
+			}
+
+			proxy.mu.Lock()
+			defer proxy.mu.Unlock()
+			if len(proxy.keys) != 2 || proxy.keys[0] == "" || proxy.keys[0] != proxy.keys[1] {
+				t.Errorf("Idempotency-Key per attempt = %q, want the same key on both", proxy.keys)
+			}
+			var first struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(proxy.firstBody, &first)
+			if first.ID == "" || result.transactionID != first.ID {
+				t.Errorf("retry got %q, first attempt made %q, want the same payment", result.transactionID, first.ID)
+			}
+		})
+	}
+}
+
+func TestStubDoesNotKeepServerErrors(t *testing.T) {
+	stub := newStripeStub(stubOptions{failCode: "server_error", failFirst: 1})
+	defer stub.Close()
+	gw := &stripe{baseURL: stub.URL, secretKey: "sk_test"}
+	ctx := withRequestKey(context.Background(), "checkout-1")
+
+	if _, err := gw.pay(ctx, money.MustParse("10.00", "INR")); !errors.Is(err, errGatewayUnavailable) {
+		t.Fatalf("first attempt err = %v, want errGatewayUnavailable", err)
+	}
+	first, err := gw.pay(ctx, money.MustParse("10.00", "INR"))
+	if err != nil {
+		t.Fatalf("retry with the same key after a 5xx: %v", err)
+	}
+	again, err := gw.pay(ctx, money.MustParse("10.00", "INR"))
+	if err != nil || again.transactionID != first.transactionID {
+		t.Errorf("same key again = %q, %v, want the saved %q", again.transactionID, err, first.transactionID)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"learngo/internal/money"
+)
+
+/*
+stripe client, stub protocol (see httpgateway.go): form encoded bodies to /v1/charges and /v1/refunds, bearer secret key
+void is like stripe does it for a charge which is not captured yet -> refund without amount releases the hold
+*/
+type stripe struct {
+	baseURL   string
+	secretKey string
+	client    *http.Client
+}
+
+func init() {
+	registerGateway("stripe", func(cfg gatewayConfig) (paymenter, error) {
+		v, err := cfg.require("stripe.base_url", "stripe.secret_key")
+		if err != nil {
+			return nil, err
+		}
+		return &stripe{baseURL: v["stripe.base_url"], secretKey: v["stripe.secret_key"]}, nil
+	})
+}
+
+// stripe request bodies know how to become a form
+type formEncoder interface {
+	form() url.Values
+}
+
+func encodeForm(in any) ([]byte, string, error) {
+	f, ok := in.(formEncoder)
+	if !ok {
+		return nil, "", fmt.Errorf("stripe: %T can not be form encoded", in)
+	}
+	return []byte(f.form().Encode()), "application/x-www-form-urlencoded", nil
+}
+
+type stripeChargeRequest struct {
+	Amount   int64
+	Currency string
+	Capture  bool
+}
+
+func (r stripeChargeRequest) form() url.Values {
+	return url.Values{"amount": {strconv.FormatInt(r.Amount, 10)}, "currency": {r.Currency}, "capture": {strconv.FormatBool(r.Capture)}}
+}
+
+type stripeCharge struct {
+	ID       string `json:"id"`
+	Object   string `json:"object"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Captured bool   `json:"captured"`
+	Status   string `json:"status"` //succeeded, voided
+}
+
+type stripeCaptureRequest struct {
+	Amount int64
+}
+
+func (r stripeCaptureRequest) form() url.Values {
+	return url.Values{"amount": {strconv.FormatInt(r.Amount, 10)}}
+}
+
+type stripeRefundRequest struct {
+	Charge string
+	Amount int64 //0 -> whole charge, on a not captured charge it voids it
+}
+
+func (r stripeRefundRequest) form() url.Values {
+	v := url.Values{"charge": {r.Charge}}
+	if r.Amount > 0 {
+		v.Set("amount", strconv.FormatInt(r.Amount, 10))
+	}
+	return v
+}
+
+type stripeRefund struct {
+	ID               string `json:"id"`
+	Object           string `json:"object"`
+	Charge           string `json:"charge"`
+	Amount           int64  `json:"amount"`
+	Currency         string `json:"currency"`
+	Status           string `json:"status"`
+	AmountRefundable int64  `json:"amount_refundable"`
+}
+
+type stripeErrorBody struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func decodeStripeError(body []byte) (string, string) {
+	var e stripeErrorBody
+	if err := json.Unmarshal(body, &e); err != nil {
+		return "", strings.TrimSpace(string(body))
+	}
+	return e.Error.Code, e.Error.Message
+}
+
+func (s *stripe) api() gatewayAPI {
+	return gatewayAPI{
+		name:        "stripe",
+		baseURL:     s.baseURL,
+		auth:        func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+s.secretKey) },
+		encode:      encodeForm,
+		client:      s.client,
+		decodeError: decodeStripeError,
+	}
+}
+
+func (c stripeCharge) result() paymentResult {
+	status := paymentAuthorized
+	switch {
+	case c.Status == "voided":
+		status = paymentVoided
+	case c.Captured:
+		status = paymentSucceeded
+	}
+	return paymentResult{transactionID: c.ID, gateway: "stripe", status: status}
+}
+
+func (s *stripe) createCharge(ctx context.Context, amount money.Money, capture bool) (paymentResult, error) {
+	var resp stripeCharge
+	req := stripeChargeRequest{Amount: amount.Minor(), Currency: strings.ToLower(amount.Currency()), Capture: capture}
+	if err := s.api().post(ctx, "/v1/charges", req, &resp); err != nil {
+		return paymentResult{gateway: "stripe", status: paymentFailed}, err
+	}
+	return resp.result(), nil
+}
+
+func (s *stripe) pay(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return s.createCharge(ctx, amount, true)
+}
+
+func (s *stripe) authorize(ctx context.Context, amount money.Money) (paymentResult, error) {
+	return s.createCharge(ctx, amount, false)
+}
+
+func (s *stripe) capture(ctx context.Context, transactionID string, amount money.Money) (paymentResult, error) {
+	var resp stripeCharge
+	if err := s.api().post(ctx, escapedPath("/v1/charges/%s/capture", transactionID), stripeCaptureRequest{Amount: amount.Minor()}, &resp); err != nil {
+		return paymentResult{}, err
+	}
+	return resp.result(), nil
+}
+
+func (s *stripe) void(ctx context.Context, transactionID string) (paymentResult, error) {
+	var resp stripeRefund
+	if err := s.api().post(ctx, "/v1/refunds", stripeRefundRequest{Charge: transactionID}, &resp); err != nil {
+		return paymentResult{}, err
+	}
+	return paymentResult{transactionID: resp.Charge, gateway: "stripe", status: paymentVoided}, nil
+}
+
+func (s *stripe) refund(ctx context.Context, transactionID string, amount money.Money) (refundResult, error) {
+	var resp stripeRefund
+	if !amount.IsPositive() { //amount 0 would mean "everything" to stripe
+		return refundResult{}, fmt.Errorf("stripe: %w: refund %v", errRefundExceedsCaptured, amount)
+	}
+	req := stripeRefundRequest{Charge: transactionID, Amount: amount.Minor()}
+	if err := s.api().post(ctx, "/v1/refunds", req, &resp); err != nil {
+		return refundResult{}, err
+	}
+	return refundResult{
+		refundID:      resp.ID,
+		transactionID: resp.Charge,
+		amount:        moneyFromWire(resp.Amount, resp.Currency),
+		remaining:     moneyFromWire(resp.AmountRefundable, resp.Currency),
+		status:        refundStatus(resp.AmountRefundable),
+	}, nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"learngo/internal/money"
+)
+
+/*
+in process fake servers for razorpay and stripe, started with httptest.NewServer on a random local port
+they keep a txLedger so capture/refund rules are same as the real thing
+stubOptions lets us make the gateway slow (latency) or fail with a provider error code
+so retries, circuit breaker and timeouts can be tried without internet
+a request with an Idempotency-Key seen before gets the first reply again, like stripe does,
+5xx replies are not kept because nothing was done, so a retry runs for real
+
+	server := newStripeStub(stubOptions{failCode: "server_error", failFirst: 2})
+	defer server.Close()
+	gw := &stripe{baseURL: server.URL, secretKey: "sk_test_local"}
+*/
+
+type stubOptions struct {
+	latency   time.Duration //added to every request
+	failCode  string        //charges fail with this code, like card_declined, insufficient_funds, server_error
+	failFirst int           //only the first failFirst charges fail, 0 means all of them
+}
+
+type stubBackend struct {
+	opts   stubOptions
+	prefix string //id prefix like pay or ch
+
+	mu      sync.Mutex
+	charges int
+	ledger  txLedger
+
+	keyMu   sync.Mutex           //one keyed request at a time, so two attempts with one key can not both charge
+	replies map[string]stubReply //path + Idempotency-Key -> first reply
+}
+
+type stubReply struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotent replays the saved reply for a repeated Idempotency-Key instead of running next again
+func (b *stubBackend) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		key = r.URL.Path + " " + key //same key on another endpoint is another request
+
+		b.keyMu.Lock()
+		defer b.keyMu.Unlock()
+		reply, ok := b.replies[key]
+		if !ok {
+			rec := httptest.NewRecorder()
+			next(rec, r)
+			reply = stubReply{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+			if rec.Body.Len() > 0 && rec.Code < 500 { //empty -> client went away before we did anything
+				if b.replies == nil {
+					b.replies = map[string]stubReply{}
+				}
+				b.replies[key] = reply
+			}
+		}
+		for k, v := range reply.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(reply.status)
+		w.Write(reply.body)
+	}
+}
+
+// waits for latency, returns false if client went away in between
+func (b *stubBackend) wait(r *http.Request) bool {
+	if b.opts.latency <= 0 {
+		return true
+	}
+	timer := time.NewTimer(b.opts.latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// error code for this charge, "" when it should go through
+func (b *stubBackend) chargeFailure() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.charges++
+	if b.opts.failFirst > 0 && b.charges > b.opts.failFirst {
+		return ""
+	}
+	return b.opts.failCode
+}
+
+// what the stub sends back when something is wrong
+type stubError struct {
+	status  int
+	code    string
+	message string
+}
+
+// http status like the providers use for each code
+func newStubError(code string, message string) stubError {
+	status := http.StatusBadRequest
+	switch code {
+	case "server_error":
+		status = http.StatusServiceUnavailable
+	case "card_declined", "insufficient_funds":
+		status = http.StatusPaymentRequired
+	case "transaction_not_found":
+		status = http.StatusNotFound
+	}
+	if message == "" {
+		message = strings.ReplaceAll(code, "_", " ")
+	}
+	return stubError{status: status, code: code, message: message}
+}
+
+func ledgerStubError(err error) stubError {
+	code := gatewayErrorCode(err)
+	var mismatch *money.CurrencyMismatchError
+	if errors.As(err, &mismatch) {
+		code = "currency_mismatch"
+	}
+	message := err.Error()
+	if known, ok := gatewayErrorCodes[code]; ok {
+		message = strings.TrimPrefix(message, known.Error()+": ") //code already says it
+	}
+	return newStubError(code, message)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// all provider endpoints we stub are POST and need credentials, stub accepts any non empty ones
+func postOnly(authorized func(r *http.Request) bool, fail func(w http.ResponseWriter, e stubError), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r) {
+			fail(w, stubError{status: http.StatusUnauthorized, code: "authentication_failed", message: "missing or invalid api credentials"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// "/v1/payments/pay%2F1/capture" -> "pay/1", "capture", escaped path so a "/" inside the id stays part of the id
+func splitIDAction(r *http.Request, prefix string) (string, string) {
+	escapedID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	id, err := url.PathUnescape(escapedID)
+	if err != nil {
+		return escapedID, action
+	}
+	return id, action
+}
+
+func razorpayAuthorized(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	return ok && id != "" && secret != ""
+}
+
+func stripeAuthorized(r *http.Request) bool {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && key != ""
+}
+
+// formInt reads a number field of a stripe form, missing -> 0
+func formInt(r *http.Request, key string) (int64, error) {
+	v := r.PostFormValue(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+func (b *stubBackend) lookup(id string) (txState, bool) {
+	b.ledger.mu.Lock()
+	defer b.ledger.mu.Unlock()
+	tx, ok := b.ledger.txs[id]
+	if !ok {
+		return txState{}, false
+	}
+	return *tx, true
+}
+
+// razorpay stub
+
+func newRazorpayStub(opts stubOptions) *httptest.Server {
+	b := &stubBackend{opts: opts, prefix: "pay"}
+	fail := func(w http.ResponseWriter, e stubError) {
+		var body razorpayErrorBody
+		body.Error.Code = "BAD_REQUEST_ERROR"
+		if e.status >= 500 {
+			body.Error.Code = "SERVER_ERROR"
+		}
+		body.Error.Reason = e.code
+		body.Error.Description = e.message
+		writeJSON(w, e.status, body)
+	}
+	payment := func(w http.ResponseWriter, id string) {
+		tx, _ := b.lookup(id)
+		status := "captured"
+		switch tx.status {
+		case paymentAuthorized, paymentVoided:
+			status = string(tx.status)
+		}
+		writeJSON(w, http.StatusOK, razorpayPayment{ID: id, Entity: "payment", Amount: tx.authorized.Minor(), Currency: tx.authorized.Currency(), Status: status})
+	}
+
+	create := func(w http.ResponseWriter, r *http.Request) {
+		if !b.wait(r) {
+			return
+		}
+		var req razorpayPaymentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		if code := b.chargeFailure(); code != "" {
+			fail(w, newStubError(code, ""))
+			return
+		}
+		amount := moneyFromWire(req.Amount, req.Currency)
+		id := newTransactionID(b.prefix)
+		if req.Capture {
+			b.ledger.charge(id, amount)
+		} else {
+			b.ledger.authorize(id, amount)
+		}
+		payment(w, id)
+	}
+	capture := func(w http.ResponseWriter, r *http.Request, id string) {
+		if !b.wait(r) {
+			return
+		}
+		var req razorpayAmountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		if err := b.ledger.capture(id, moneyFromWire(req.Amount, req.Currency)); err != nil {
+			fail(w, ledgerStubError(err))
+			return
+		}
+		payment(w, id)
+	}
+	refund := func(w http.ResponseWriter, r *http.Request, id string) {
+		if !b.wait(r) {
+			return
+		}
+		var req razorpayAmountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		refund, err := b.ledger.refund(id, moneyFromWire(req.Amount, req.Currency))
+		if err != nil {
+			fail(w, ledgerStubError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, razorpayRefund{
+			ID:               newTransactionID("rfnd"),
+			Entity:           "refund",
+			PaymentID:        refund.transactionID,
+			Amount:           refund.amount.Minor(),
+			Currency:         refund.amount.Currency(),
+			Status:           "processed",
+			AmountRefundable: refund.remaining.Minor(),
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/payments", postOnly(razorpayAuthorized, fail, b.idempotent(create)))
+	mux.HandleFunc("/v1/payments/", postOnly(razorpayAuthorized, fail, b.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		id, action := splitIDAction(r, "/v1/payments/")
+		switch action {
+		case "capture":
+			capture(w, r, id)
+		case "refund":
+			refund(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+	return httptest.NewServer(mux)
+}
+
+// stripe stub
+
+func newStripeStub(opts stubOptions) *httptest.Server {
+	b := &stubBackend{opts: opts, prefix: "ch"}
+	fail := func(w http.ResponseWriter, e stubError) {
+		var body stripeErrorBody
+		body.Error.Type = "invalid_request_error"
+		switch {
+		case e.status == http.StatusPaymentRequired:
+			body.Error.Type = "card_error"
+		case e.status >= 500:
+			body.Error.Type = "api_error"
+		}
+		body.Error.Code = e.code
+		body.Error.Message = e.message
+		writeJSON(w, e.status, body)
+	}
+	charge := func(w http.ResponseWriter, id string) {
+		tx, _ := b.lookup(id)
+		status := "succeeded"
+		if tx.status == paymentVoided {
+			status = "voided"
+		}
+		writeJSON(w, http.StatusOK, stripeCharge{
+			ID:       id,
+			Object:   "charge",
+			Amount:   tx.authorized.Minor(),
+			Currency: strings.ToLower(tx.authorized.Currency()),
+			Captured: tx.status != paymentAuthorized && tx.status != paymentVoided,
+			Status:   status,
+		})
+	}
+
+	create := func(w http.ResponseWriter, r *http.Request) {
+		if !b.wait(r) {
+			return
+		}
+		minor, err := formInt(r, "amount")
+		if err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		if code := b.chargeFailure(); code != "" {
+			fail(w, newStubError(code, ""))
+			return
+		}
+		amount := moneyFromWire(minor, r.PostFormValue("currency"))
+		id := newTransactionID(b.prefix)
+		if r.PostFormValue("capture") != "false" { //stripe captures unless told not to
+			b.ledger.charge(id, amount)
+		} else {
+			b.ledger.authorize(id, amount)
+		}
+		charge(w, id)
+	}
+	capture := func(w http.ResponseWriter, r *http.Request, id string) {
+		if !b.wait(r) {
+			return
+		}
+		tx, ok := b.lookup(id)
+		if !ok {
+			fail(w, newStubError("transaction_not_found", "no such charge: "+id))
+			return
+		}
+		minor, err := formInt(r, "amount")
+		if err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		if minor == 0 { //no amount -> capture everything authorized
+			minor = tx.authorized.Minor()
+		}
+		if err := b.ledger.capture(id, money.FromMinor(minor, tx.authorized.Currency())); err != nil {
+			fail(w, ledgerStubError(err))
+			return
+		}
+		charge(w, id)
+	}
+	refund := func(w http.ResponseWriter, r *http.Request) {
+		if !b.wait(r) {
+			return
+		}
+		chargeID := r.PostFormValue("charge")
+		tx, ok := b.lookup(chargeID)
+		if !ok {
+			fail(w, newStubError("transaction_not_found", "no such charge: "+chargeID))
+			return
+		}
+		minor, err := formInt(r, "amount")
+		if err != nil {
+			fail(w, newStubError("invalid_request", err.Error()))
+			return
+		}
+		if tx.status == paymentAuthorized && minor == 0 { //refund of a not captured charge releases the hold
+			if err := b.ledger.void(chargeID); err != nil {
+				fail(w, ledgerStubError(err))
+				return
+			}
+			writeJSON(w, http.StatusOK, stripeRefund{ID: newTransactionID("re"), Object: "refund", Charge: chargeID, Amount: tx.authorized.Minor(),
+				Currency: strings.ToLower(tx.authorized.Currency()), Status: "succeeded"})
+			return
+		}
+		if minor == 0 { //no amount -> refund what is left
+			minor = tx.captured.Minor() - tx.refunded.Minor()
+		}
+		refund, err := b.ledger.refund(chargeID, money.FromMinor(minor, tx.captured.Currency()))
+		if err != nil {
+			fail(w, ledgerStubError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, stripeRefund{
+			ID:               newTransactionID("re"),
+			Object:           "refund",
+			Charge:           refund.transactionID,
+			Amount:           refund.amount.Minor(),
+			Currency:         strings.ToLower(refund.amount.Currency()),
+			Status:           "succeeded",
+			AmountRefundable: refund.remaining.Minor(),
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/charges", postOnly(stripeAuthorized, fail, b.idempotent(create)))
+	mux.HandleFunc("/v1/refunds", postOnly(stripeAuthorized, fail, b.idempotent(refund)))
+	mux.HandleFunc("/v1/charges/", postOnly(stripeAuthorized, fail, b.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		id, action := splitIDAction(r, "/v1/charges/")
+		switch action {
+		case "capture":
+			capture(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+	return httptest.NewServer(mux)
+}
+
+func stubDemo(amount money.Money) {
+	fmt.Println("+++++HTTP STUB SERVERS+++++")
+	declining := newRazorpayStub(stubOptions{failCode: "card_declined"})
+	defer declining.Close()
+	if _, err := (payment{gateway: &razorpay{baseURL: declining.URL, keyID: "rzp_test_local", keySecret: "secret"}}).makePayment(context.Background(), amount); errors.Is(err, errDeclined) {
+		fmt.Println("declined by razorpay stub:", err)
+	}
+
+	//slow stripe + short timeout on our side
+	slow := newStripeStub(stubOptions{latency: 200 * time.Millisecond})
+	defer slow.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := (payment{gateway: &stripe{baseURL: slow.URL, secretKey: "sk_test_local"}}).makePayment(ctx, amount); err != nil {
+		fmt.Println("stripe stub too slow:", err)
+	}
+
+	//down for the first 2 calls, retry gets through on the third
+	flaky := newStripeStub(stubOptions{failCode: "server_error", failFirst: 2})
+	defer flaky.Close()
+	withRetry := payment{gateway: retryingGateway{gateway: &stripe{baseURL: flaky.URL, secretKey: "sk_test_local"}, policy: defaultRetryPolicy()}}
+	result, err := withRetry.makePayment(context.Background(), amount)
+	fmt.Println("flaky stripe stub:", result.transactionID, result.status, "err:", err)
+}
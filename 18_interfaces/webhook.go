@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+gateway tells us later (async) that the payment is really done by calling our URL -> webhook
+anyone can call a URL, so every request is signed with HMAC-SHA256 using a secret only we and the gateway know
+we calculate the same HMAC on the body and compare, if it does not match the request is fake
+- razorpay: X-Razorpay-Signature = hex(hmac(body))
+- stripe: Stripe-Signature = "t=<unix time>,v1=hex(hmac(t + "." + body))"
+gateways send the same event again if we were slow, so we remember event ids (dedup)
+an id is remembered only after the event is applied, so a delivery which failed on our side is tried again
+ids are per gateway, razorpay evt_1 and stripe evt_1 are two different events
+and old events are rejected, so someone who copied a signed request can not send it again days later (replay)
+*/
+
+const maxWebhookBody = 1 << 20 //1 MB is more than enough for an event
+
+var (
+	errBadSignature = errors.New("webhook signature mismatch")
+	errStaleEvent   = errors.New("webhook event too old")
+	errMissingID    = errors.New("webhook event has no id")
+	errNoOrderID    = errors.New("webhook event has no order_id")
+	errOrderMissing = errors.New("order not found")
+)
+
+// which order status each gateway event means
+var webhookStatusMap = map[string]map[string]OrderStatus{
+	"razorpay": {
+		"payment.captured": Confrimed,
+		"payment.failed":   Cancelled,
+		"refund.processed": Returned,
+	},
+	"stripe": {
+		"charge.succeeded": Confrimed,
+		"charge.failed":    Cancelled,
+		"charge.refunded":  Returned,
+	},
+}
+
+// provider independent view of an event
+type webhookEvent struct {
+	id        string
+	gateway   string
+	eventType string
+	orderID   string
+	createdAt time.Time
+}
+
+func hmacHex(secret string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRazorpayPayload is what razorpay puts in X-Razorpay-Signature
+func signRazorpayPayload(secret string, body []byte) string {
+	return hmacHex(secret, body)
+}
+
+// signStripePayload is what stripe puts in Stripe-Signature
+func signStripePayload(secret string, body []byte, at time.Time) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + t + ",v1=" + hmacHex(secret, []byte(t), []byte("."), body)
+}
+
+// hmac.Equal compares in constant time so attacker can not guess the signature byte by byte
+func verifyRazorpaySignature(secret string, body []byte, header string) error {
+	if !hmac.Equal([]byte(signRazorpayPayload(secret, body)), []byte(header)) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// returns the signed timestamp too, stripe signs the time so it can not be changed
+func verifyStripeSignature(secret string, body []byte, header string) (time.Time, error) {
+	var t string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return time.Time{}, errBadSignature
+	}
+	expected := hmacHex(secret, []byte(t), []byte("."), body)
+	for _, sig := range signatures { //during secret rotation stripe sends more than one v1
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			return time.Unix(unix, 0), nil
+		}
+	}
+	return time.Time{}, errBadSignature
+}
+
+type razorpayWebhook struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	CreatedAt int64  `json:"created_at"`
+	Payload   struct {
+		Payment struct {
+			Entity struct {
+				ID    string            `json:"id"`
+				Notes map[string]string `json:"notes"`
+			} `json:"entity"`
+		} `json:"payment"`
+	} `json:"payload"`
+}
+
+type stripeWebhook struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object struct {
+			ID       string            `json:"id"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// orderStatusBook is the small order store webhooks update, real app will use the order repository
+type orderStatusBook struct {
+	mu     sync.Mutex
+	orders map[string]OrderStatus
+}
+
+func newOrderStatusBook() *orderStatusBook {
+	return &orderStatusBook{orders: map[string]OrderStatus{}}
+}
+
+func (b *orderStatusBook) add(orderID string, status OrderStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.orders[orderID] = status
+}
+
+func (b *orderStatusBook) status(orderID string) (OrderStatus, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.orders[orderID]
+	return s, ok
+}
+
+func (b *orderStatusBook) changeStatus(orderID string, to OrderStatus) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	from, ok := b.orders[orderID]
+	if !ok {
+		return fmt.Errorf("%w: %q", errOrderMissing, orderID)
+	}
+	if err := canTransition(from, to); err != nil {
+		return err
+	}
+	b.orders[orderID] = to
+	return nil
+}
+
+type webhookHandler struct {
+	secrets   map[string]string //gateway name -> signing secret
+	tolerance time.Duration     //older events are rejected, also how long we remember event ids
+	now       func() time.Time
+	orders    *orderStatusBook
+
+	mu   sync.Mutex
+	seen map[string]time.Time //gateway:event id -> when we applied it
+}
+
+func newWebhookHandler(secrets map[string]string, orders *orderStatusBook) *webhookHandler {
+	return &webhookHandler{
+		secrets:   secrets,
+		tolerance: 5 * time.Minute,
+		now:       time.Now,
+		orders:    orders,
+		seen:      map[string]time.Time{},
+	}
+}
+
+// routes: /webhooks/razorpay and /webhooks/stripe
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	gateway := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	secret, ok := h.secrets[gateway]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	event, err := h.parse(gateway, secret, body, r.Header)
+	switch {
+	case errors.Is(err, errBadSignature):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if age := h.now().Sub(event.createdAt); age > h.tolerance || age < -h.tolerance {
+		http.Error(w, errStaleEvent.Error(), http.StatusBadRequest)
+		return
+	}
+	key := event.gateway + ":" + event.id
+	if h.seenBefore(key) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "duplicate"}) //2xx so gateway stops sending it
+		return
+	}
+
+	to, known := webhookStatusMap[gateway][event.eventType]
+	if !known {
+		h.remember(key)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "unhandled event type"})
+		return
+	}
+	if strings.TrimSpace(event.orderID) == "" {
+		//no order to look up, a 404 here would make the gateway send it again forever
+		http.Error(w, errNoOrderID.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.orders.changeStatus(event.orderID, to)
+	switch {
+	case errors.Is(err, errOrderMissing):
+		//the order may not be saved yet (webhook came before our own write), 404 makes the gateway send it again later
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		//sending it again will not fix an illegal transition, so still 2xx
+		h.remember(key)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": err.Error()})
+		return
+	}
+	h.remember(key)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "applied", "order": event.orderID, "orderStatus": to.String()})
+}
+
+func (h *webhookHandler) parse(gateway string, secret string, body []byte, header http.Header) (webhookEvent, error) {
+	switch gateway {
+	case "razorpay":
+		if err := verifyRazorpaySignature(secret, body, header.Get("X-Razorpay-Signature")); err != nil {
+			return webhookEvent{}, err
+		}
+		var e razorpayWebhook
+		if err := json.Unmarshal(body, &e); err != nil {
+			return webhookEvent{}, fmt.Errorf("bad razorpay event: %w", err)
+		}
+		if strings.TrimSpace(e.ID) == "" {
+			return webhookEvent{}, errMissingID
+		}
+		entity := e.Payload.Payment.Entity
+		return webhookEvent{id: e.ID, gateway: gateway, eventType: e.Event, orderID: entity.Notes["order_id"], createdAt: time.Unix(e.CreatedAt, 0)}, nil
+	case "stripe":
+		signedAt, err := verifyStripeSignature(secret, body, header.Get("Stripe-Signature"))
+		if err != nil {
+			return webhookEvent{}, err
+		}
+		var e stripeWebhook
+		if err := json.Unmarshal(body, &e); err != nil {
+			return webhookEvent{}, fmt.Errorf("bad stripe event: %w", err)
+		}
+		if strings.TrimSpace(e.ID) == "" {
+			return webhookEvent{}, errMissingID
+		}
+		return webhookEvent{id: e.ID, gateway: gateway, eventType: e.Type, orderID: e.Data.Object.Metadata["order_id"], createdAt: signedAt}, nil
+	}
+	return webhookEvent{}, fmt.Errorf("unknown gateway %q", gateway)
+}
+
+// seenBefore says if the event was already applied, old ids are cleaned on the way
+func (h *webhookHandler) seenBefore(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	for k, at := range h.seen {
+		if now.Sub(at) > 2*h.tolerance { //older events are rejected as stale anyway
+			delete(h.seen, k)
+		}
+	}
+	_, dup := h.seen[key]
+	return dup
+}
+
+// remember is called only when we are done with the event, so a failed one is not marked as seen
+func (h *webhookHandler) remember(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seen[key] = h.now()
+}
+
+func webhookDemo() {
+	fmt.Println("+++++WEBHOOKS+++++")
+	orders := newOrderStatusBook()
+	orders.add("1", Recieved)
+	orders.add("2", Recieved)
+	secrets := map[string]string{"razorpay": "rzp_secret", "stripe": "whsec_test"}
+	server := httptest.NewServer(newWebhookHandler(secrets, orders))
+	defer server.Close()
+
+	send := func(gateway string, body []byte, header string, value string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/webhooks/"+gateway, bytes.NewReader(body))
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		defer resp.Body.Close()
+		reply, _ := io.ReadAll(resp.Body)
+		fmt.Println(gateway, resp.StatusCode, strings.TrimSpace(string(reply)))
+	}
+
+	now := time.Now()
+	rzpBody := []byte(fmt.Sprintf(`{"id":"evt_1","event":"payment.captured","created_at":%d,"payload":{"payment":{"entity":{"id":"pay_1","notes":{"order_id":"1"}}}}}`, now.Unix()))
+	send("razorpay", rzpBody, "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", rzpBody))
+	send("razorpay", rzpBody, "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", rzpBody)) //same event again
+	send("razorpay", rzpBody, "X-Razorpay-Signature", signRazorpayPayload("wrong_secret", rzpBody))
+	unknown := []byte(fmt.Sprintf(`{"id":"evt_4","event":"payment.captured","created_at":%d,"payload":{"payment":{"entity":{"id":"pay_2","notes":{"order_id":"99"}}}}}`, now.Unix()))
+	send("razorpay", unknown, "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", unknown)) //404, gateway will send it again
+
+	stripeBody := []byte(`{"id":"evt_2","type":"charge.succeeded","data":{"object":{"id":"ch_1","metadata":{"order_id":"2"}}}}`)
+	send("stripe", stripeBody, "Stripe-Signature", signStripePayload("whsec_test", stripeBody, now))
+	old := []byte(`{"id":"evt_3","type":"charge.refunded","data":{"object":{"id":"ch_1","metadata":{"order_id":"2"}}}}`)
+	send("stripe", old, "Stripe-Signature", signStripePayload("whsec_test", old, now.Add(-time.Hour))) //replayed old request
+
+	for _, id := range []string{"1", "2"} {
+		status, _ := orders.status(id)
+		fmt.Println("order", id, "is", status)
+	}
+}
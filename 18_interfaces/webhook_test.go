@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var webhookTestNow = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func newTestWebhookHandler() (*webhookHandler, *orderStatusBook) {
+	orders := newOrderStatusBook()
+	orders.add("1", Recieved)
+	h := newWebhookHandler(map[string]string{"razorpay": "rzp_secret", "stripe": "whsec_test"}, orders)
+	h.now = func() time.Time { return webhookTestNow }
+	return h, orders
+}
+
+func razorpayEvent(id string, orderID string) string {
+	return fmt.Sprintf(`{"id":%q,"event":"payment.captured","created_at":%d,"payload":{"payment":{"entity":{"id":"pay_1","notes":{"order_id":%q}}}}}`,
+		id, webhookTestNow.Unix(), orderID)
+}
+
+func stripeEvent(id string, orderID string) string {
+	return fmt.Sprintf(`{"id":%q,"type":"charge.succeeded","data":{"object":{"id":"ch_1","metadata":{"order_id":%q}}}}`, id, orderID)
+}
+
+func deliver(h http.Handler, gateway string, body string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/"+gateway, strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookSignatures(t *testing.T) {
+	rzp := razorpayEvent("evt_1", "1")
+	stripe := stripeEvent("evt_1", "1")
+	tests := []struct {
+		name    string
+		gateway string
+		body    string
+		header  string
+		value   string
+		want    int
+	}{
+		{"razorpay valid", "razorpay", rzp, "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", []byte(rzp)), http.StatusOK},
+		{"razorpay tampered body", "razorpay", strings.Replace(rzp, `"1"`, `"2"`, 1), "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", []byte(rzp)), http.StatusUnauthorized},
+		{"razorpay wrong secret", "razorpay", rzp, "X-Razorpay-Signature", signRazorpayPayload("wrong", []byte(rzp)), http.StatusUnauthorized},
+		{"razorpay missing signature", "razorpay", rzp, "", "", http.StatusUnauthorized},
+		{"stripe valid", "stripe", stripe, "Stripe-Signature", signStripePayload("whsec_test", []byte(stripe), webhookTestNow), http.StatusOK},
+		{"stripe tampered body", "stripe", strings.Replace(stripe, "charge.succeeded", "charge.refunded", 1), "Stripe-Signature", signStripePayload("whsec_test", []byte(stripe), webhookTestNow), http.StatusUnauthorized},
+		{"stripe bad signature", "stripe", stripe, "Stripe-Signature", "t=1,v1=abcd", http.StatusUnauthorized},
+		{"stripe missing signature", "stripe", stripe, "", "", http.StatusUnauthorized},
+		{"stripe old event", "stripe", stripe, "Stripe-Signature", signStripePayload("whsec_test", []byte(stripe), webhookTestNow.Add(-time.Hour)), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestWebhookHandler()
+			rec := deliver(h, tt.gateway, tt.body, tt.header, tt.value)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	h, orders := newTestWebhookHandler()
+	body := razorpayEvent("evt_1", "1")
+	sig := signRazorpayPayload("rzp_secret", []byte(body))
+
+	first := deliver(h, "razorpay", body, "X-Razorpay-Signature", sig)
+	second := deliver(h, "razorpay", body, "X-Razorpay-Signature", sig)
+	if first.Code != http.StatusOK || !strings.Contains(first.Body.String(), "applied") {
+		t.Fatalf("first delivery = %d %s", first.Code, first.Body.String())
+	}
+	if second.Code != http.StatusOK || !strings.Contains(second.Body.String(), "duplicate") {
+		t.Errorf("second delivery = %d %s, want duplicate", second.Code, second.Body.String())
+	}
+	if status, _ := orders.status("1"); status != Confrimed {
+		t.Errorf("order status = %v, want %v", status, Confrimed)
+	}
+
+	//same id from another gateway is another event
+	orders.add("1", Recieved)
+	stripe := stripeEvent("evt_1", "1")
+	rec := deliver(h, "stripe", stripe, "Stripe-Signature", signStripePayload("whsec_test", []byte(stripe), webhookTestNow))
+	if !strings.Contains(rec.Body.String(), "applied") {
+		t.Errorf("stripe evt_1 = %d %s, want applied", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebhookUnknownOrderIsRetried(t *testing.T) {
+	h, orders := newTestWebhookHandler()
+	body := razorpayEvent("evt_9", "9")
+	sig := signRazorpayPayload("rzp_secret", []byte(body))
+
+	if rec := deliver(h, "razorpay", body, "X-Razorpay-Signature", sig); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown order = %d, want 404 so the gateway retries", rec.Code)
+	}
+	orders.add("9", Recieved) //our own write lands, gateway sends the event again
+	rec := deliver(h, "razorpay", body, "X-Razorpay-Signature", sig)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "applied") {
+		t.Errorf("retried delivery = %d %s, want applied", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebhookRejectsEmptyID(t *testing.T) {
+	h, _ := newTestWebhookHandler()
+	body := razorpayEvent("", "1")
+	rec := deliver(h, "razorpay", body, "X-Razorpay-Signature", signRazorpayPayload("rzp_secret", []byte(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty id = %d, want 400", rec.Code)
+	}
+}
+
+func TestWebhookRejectsEmptyOrderID(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway string
+		body    string
+		header  string
+		sign    func(body string) string
+	}{
+		{"razorpay", "razorpay", razorpayEvent("evt_1", ""), "X-Razorpay-Signature", func(b string) string { return signRazorpayPayload("rzp_secret", []byte(b)) }},
+		{"razorpay blank", "razorpay", razorpayEvent("evt_1", "  "), "X-Razorpay-Signature", func(b string) string { return signRazorpayPayload("rzp_secret", []byte(b)) }},
+		{"stripe", "stripe", stripeEvent("evt_1", ""), "Stripe-Signature", func(b string) string { return signStripePayload("whsec_test", []byte(b), webhookTestNow) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestWebhookHandler()
+			rec := deliver(h, tt.gateway, tt.body, tt.header, tt.sign(tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("empty order_id = %d, want 400 so the gateway stops retrying", rec.Code)
+			}
+		})
+	}
+}
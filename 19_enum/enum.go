@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // enumerated type
 type OrderStatus int
@@ -11,11 +14,54 @@ const (
 	Prepared                     //2
 	Shipped                      //3
 	Delivered                    //4
+	Cancelled                    //5
+	Returned                     //6
 )
 
-func changeStatus(status OrderStatus) {
-	fmt.Println("updated order status to", status)
-}
 func main() {
-	changeStatus(Shipped)
+	myOrder := newOrder("1")
+
+	//happy path, every step is allowed by the transition table
+	for _, next := range []OrderStatus{Confrimed, Prepared, Shipped} {
+		if err := myOrder.changeStatus(next, "warehouse"); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+	fmt.Println("updated order status to", myOrder.status)
+
+	//illegal jump -> shipped order can not go back to prepared
+	if err := myOrder.changeStatus(Prepared, "warehouse"); err != nil {
+		fmt.Println("error:", err)
+	}
+
+	if err := myOrder.changeStatus(Delivered, "courier"); err != nil {
+		fmt.Println("error:", err)
+	}
+	if err := myOrder.changeStatus(Returned, "customer"); err != nil {
+		fmt.Println("error:", err)
+	}
+
+	//who changed what and when
+	for _, t := range myOrder.history {
+		fmt.Println(t.from, "->", t.to, "by", t.by, "at", t.at.Format("15:04:05"))
+	}
+
+	//round trip through JSON like our http payloads
+	payload, _ := json.Marshal(struct {
+		ID     string      `json:"id"`
+		Status OrderStatus `json:"status"`
+	}{myOrder.id, myOrder.status})
+	fmt.Println(string(payload)) // {"id":"1","status":"Returned"}
+
+	var fromAPI struct {
+		Status OrderStatus `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(`{"status":"shipped"}`), &fromAPI); err != nil {
+		fmt.Println("error:", err)
+	}
+	fmt.Println("status from api:", fromAPI.Status)
+
+	if _, err := ParseOrderStatus("lost"); err != nil {
+		fmt.Println("error:", err)
+	}
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from    OrderStatus
+		to      OrderStatus
+		wantErr any //nil, *transitionError or *unknownStatusError
+	}{
+		{Recieved, Confrimed, nil},
+		{Recieved, Cancelled, nil},
+		{Confrimed, Prepared, nil},
+		{Confrimed, Cancelled, nil},
+		{Prepared, Shipped, nil},
+		{Prepared, Cancelled, nil},
+		{Shipped, Delivered, nil},
+		{Delivered, Returned, nil},
+
+		{Recieved, Delivered, &transitionError{}},
+		{Recieved, Recieved, &transitionError{}},
+		{Shipped, Cancelled, &transitionError{}},
+		{Delivered, Recieved, &transitionError{}},
+		{Cancelled, Recieved, &transitionError{}},
+		{Returned, Delivered, &transitionError{}},
+
+		{OrderStatus(-1), Confrimed, &unknownStatusError{}},
+		{Recieved, OrderStatus(42), &unknownStatusError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"->"+tt.to.String(), func(t *testing.T) {
+			err := canTransition(tt.from, tt.to)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+			case *transitionError:
+				if !errors.As(err, &want) || want.from != tt.from || want.to != tt.to {
+					t.Errorf("err = %v, want transitionError from %v to %v", err, tt.from, tt.to)
+				}
+			case *unknownStatusError:
+				if !errors.As(err, &want) {
+					t.Errorf("err = %v, want unknownStatusError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeStatusKeepsHistory(t *testing.T) {
+	o := newOrder("1")
+	if err := o.changeStatus(Confrimed, "manish"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.changeStatus(Delivered, "manish"); err == nil {
+		t.Fatal("illegal jump was accepted")
+	}
+	if o.status != Confrimed || len(o.history) != 1 || o.history[0].by != "manish" {
+		t.Errorf("status = %v history = %+v, want Confirmed with one entry", o.status, o.history)
+	}
+}
+
+func TestParseOrderStatus(t *testing.T) {
+	tests := []struct {
+		input string
+		want  OrderStatus
+		ok    bool
+	}{
+		{"Shipped", Shipped, true},
+		{"shipped", Shipped, true},
+		{"SHIPPED", Shipped, true},
+		{" Shipped ", Shipped, true},
+		{"recieved", Recieved, true},
+		{"Confrimed", Confrimed, true},
+		{"canceled", Cancelled, true},
+		{"CANCELED", Cancelled, true},
+		{"", 0, false},
+		{"lost", 0, false},
+		{"3", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseOrderStatus(tt.input)
+			if !tt.ok {
+				var pe *parseStatusError
+				if !errors.As(err, &pe) {
+					t.Errorf("err = %v, want parseStatusError", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseOrderStatus(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderStatusString(t *testing.T) {
+	if got := Delivered.String(); got != "Delivered" {
+		t.Errorf("Delivered.String() = %q", got)
+	}
+	if got := OrderStatus(42).String(); got != "OrderStatus(42)" {
+		t.Errorf("OrderStatus(42).String() = %q", got)
+	}
+}
+
+func TestOrderStatusJSONRoundTrip(t *testing.T) {
+	for s := Recieved; s <= Returned; s++ {
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", s, err)
+		}
+		var back OrderStatus
+		if err := json.Unmarshal(data, &back); err != nil || back != s {
+			t.Errorf("%s -> %v, %v, want %v", data, back, err, s)
+		}
+
+		text, err := s.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromText OrderStatus
+		if err := fromText.UnmarshalText(text); err != nil || fromText != s {
+			t.Errorf("text %s -> %v, %v, want %v", text, fromText, err, s)
+		}
+	}
+
+	//status inside a struct goes through the same methods
+	type shipment struct {
+		Status OrderStatus `json:"status"`
+	}
+	var got shipment
+	if err := json.Unmarshal([]byte(`{"status":"canceled"}`), &got); err != nil || got.Status != Cancelled {
+		t.Errorf("alias in struct = %v, %v", got.Status, err)
+	}
+}
+
+func TestOrderStatusJSONErrors(t *testing.T) {
+	if _, err := json.Marshal(OrderStatus(42)); err == nil {
+		t.Error("out of range status marshalled")
+	}
+	if _, err := OrderStatus(-1).MarshalText(); err == nil {
+		t.Error("negative status marshalled as text")
+	}
+	for _, input := range []string{`"lost"`, `3`, `null`, `{}`} {
+		var s OrderStatus
+		if err := json.Unmarshal([]byte(input), &s); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want error", input, s)
+		}
+	}
+}
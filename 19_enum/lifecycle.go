@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Enum alone does not stop us from doing Recieved -> Delivered directly
+so we keep a transition table -> for every status the list of statuses it is allowed to move to
+anything which is not in the table is an illegal jump and we return an error for it
+Delivered can only be Returned, Cancelled and Returned are final (no entry in the table)
+*/
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	Recieved:  {Confrimed, Cancelled},
+	Confrimed: {Prepared, Cancelled},
+	Prepared:  {Shipped, Cancelled},
+	Shipped:   {Delivered},
+	Delivered: {Returned},
+}
+
+// typed error so caller can check with errors.As and read from/to
+type transitionError struct {
+	from OrderStatus
+	to   OrderStatus
+}
+
+func (e *transitionError) Error() string {
+	return fmt.Sprintf("illegal order status transition from %v to %v", e.from, e.to)
+}
+
+// returned when the value is outside our const block like OrderStatus(42)
+type unknownStatusError struct {
+	status OrderStatus
+}
+
+func (e *unknownStatusError) Error() string {
+	return fmt.Sprintf("unknown order status %d", int(e.status))
+}
+
+func (s OrderStatus) isValid() bool {
+	return s >= Recieved && s <= Returned
+}
+
+// final statuses have nowhere to go
+func (s OrderStatus) isFinal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+func canTransition(from OrderStatus, to OrderStatus) error {
+	if !from.isValid() {
+		return &unknownStatusError{status: from}
+	}
+	if !to.isValid() {
+		return &unknownStatusError{status: to}
+	}
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &transitionError{from: from, to: to}
+}
+
+// one entry of the audit trail -> who moved the order and when
+type transition struct {
+	from OrderStatus
+	to   OrderStatus
+	by   string
+	at   time.Time
+}
+
+type order struct {
+	id      string
+	status  OrderStatus
+	history []transition
+}
+
+func newOrder(id string) *order {
+	return &order{id: id, status: Recieved}
+}
+
+// pointer reciever because we are updating the status and appending history
+func (o *order) changeStatus(to OrderStatus, by string) error {
+	if err := canTransition(o.status, to); err != nil {
+		return err
+	}
+	o.history = append(o.history, transition{from: o.status, to: to, by: by, at: time.Now()})
+	o.status = to
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+Printing the enum gives the number (Shipped -> 3) because OrderStatus is just an int
+fmt checks if the type has String() method (Stringer interface) and uses it, so we add one
+for JSON and config files we add Marshal/Unmarshal methods, encoding/json and encoding/text call them implicitly (interfaces again)
+*/
+
+// index of the slice is the value of the const, so keep it in the same order as the const block
+var orderStatusNames = []string{
+	"Received",
+	"Confirmed",
+	"Prepared",
+	"Shipped",
+	"Delivered",
+	"Cancelled",
+	"Returned",
+}
+
+// old spellings still coming from the const names, accept them while parsing
+var orderStatusAliases = map[string]OrderStatus{
+	"recieved":  Recieved,
+	"confrimed": Confrimed,
+	"canceled":  Cancelled,
+}
+
+type parseStatusError struct {
+	input string
+}
+
+func (e *parseStatusError) Error() string {
+	return fmt.Sprintf("unknown order status %q, expected one of %s", e.input, strings.Join(orderStatusNames, ", "))
+}
+
+func (s OrderStatus) String() string {
+	if !s.isValid() {
+		return fmt.Sprintf("OrderStatus(%d)", int(s))
+	}
+	return orderStatusNames[s]
+}
+
+// case insensitive, "shipped", "SHIPPED" and " Shipped " all give Shipped
+func ParseOrderStatus(name string) (OrderStatus, error) {
+	name = strings.TrimSpace(name)
+	for i, n := range orderStatusNames {
+		if strings.EqualFold(n, name) {
+			return OrderStatus(i), nil
+		}
+	}
+	if s, ok := orderStatusAliases[strings.ToLower(name)]; ok {
+		return s, nil
+	}
+	return 0, &parseStatusError{input: name}
+}
+
+func (s OrderStatus) MarshalText() ([]byte, error) {
+	if !s.isValid() {
+		return nil, &unknownStatusError{status: s}
+	}
+	return []byte(s.String()), nil
+}
+
+// pointer reciever because we are writing into s
+func (s *OrderStatus) UnmarshalText(text []byte) error {
+	parsed, err := ParseOrderStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+func (s OrderStatus) MarshalJSON() ([]byte, error) {
+	text, err := s.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+func (s *OrderStatus) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("order status must be a JSON string: %w", err)
+	}
+	return s.UnmarshalText([]byte(name))
+}
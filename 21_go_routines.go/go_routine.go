@@ -22,4 +22,6 @@ func main() {
 	// fmt.Println("main done")
 	time.Sleep(time.Second) // use because when our go routines was unning our program is exit
 
+	supervisorDemo()
+
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+panic inside any go task(i) kills the whole program, not only that goroutine
+supervisor starts named goroutines and watches them:
+- panic is recovered, saved with its stack trace, program keeps running
+- restart policy: never, always (even after normal return) or on-failure (error or panic)
+- restarts wait with backoff (doubling every time) and stop after maxRestarts
+- backoff is at least minRestartBackoff and at most maxBackoff (maxRestartBackoff when not set),
+  so a child which returns at once can not spin the cpu and doubling never overflows
+- snapshot() tells state of every goroutine, like `ps` for our goroutines
+- backoff waits and startedAt come from the supervisor Clock, so a test moves fake time instead of sleeping
+*/
+
+type restartPolicy int
+
+const (
+	restartNever restartPolicy = iota
+	restartAlways
+	restartOnFailure
+)
+
+func (p restartPolicy) String() string {
+	switch p {
+	case restartNever:
+		return "never"
+	case restartAlways:
+		return "always"
+	case restartOnFailure:
+		return "on-failure"
+	default:
+		return fmt.Sprintf("restartPolicy(%d)", int(p))
+	}
+}
+
+type childState string
+
+const (
+	stateRunning    childState = "running"
+	stateRestarting childState = "restarting" //waiting for backoff
+	stateCompleted  childState = "completed"  //returned nil, no restart wanted
+	stateFailed     childState = "failed"     //error/panic and policy says no restart
+	stateGaveUp     childState = "gave-up"    //maxRestarts reached
+	stateStopped    childState = "stopped"    //supervisor stopped it
+)
+
+const (
+	minRestartBackoff = 10 * time.Millisecond
+	maxRestartBackoff = time.Minute //cap when spec does not give maxBackoff
+)
+
+var errChildExists = errors.New("goroutine with this name is already supervised")
+
+type panicError struct {
+	value any
+	stack string
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+type childSpec struct {
+	name        string
+	run         func(ctx context.Context) error //must return when ctx is done
+	policy      restartPolicy
+	maxRestarts int           //0 -> no limit
+	backoff     time.Duration //first restart delay, doubled each time
+	maxBackoff  time.Duration
+}
+
+// withBackoffLimits keeps backoff between minRestartBackoff and the cap
+func (spec childSpec) withBackoffLimits() childSpec {
+	if spec.maxBackoff <= 0 {
+		spec.maxBackoff = maxRestartBackoff
+	}
+	spec.maxBackoff = max(spec.maxBackoff, minRestartBackoff)
+	spec.backoff = min(max(spec.backoff, minRestartBackoff), spec.maxBackoff)
+	return spec
+}
+
+type childStatus struct {
+	name      string
+	policy    restartPolicy
+	state     childState
+	restarts  int
+	lastError error
+	lastStack string //stack of the last panic, empty if it never panicked
+	startedAt time.Time
+}
+
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	clock  Clock
+
+	mu       sync.Mutex
+	children map[string]*childStatus
+}
+
+// clock times the restart backoff, nil -> real time
+func newSupervisor(ctx context.Context, clock Clock) *supervisor {
+	if clock == nil {
+		clock = realClock{}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &supervisor{ctx: ctx, cancel: cancel, clock: clock, children: map[string]*childStatus{}}
+}
+
+func (s *supervisor) start(spec childSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.children[spec.name]; ok {
+		return fmt.Errorf("%w: %s", errChildExists, spec.name)
+	}
+	s.children[spec.name] = &childStatus{name: spec.name, policy: spec.policy, state: stateRunning, startedAt: s.clock.Now()}
+	s.wg.Add(1)
+	go s.supervise(spec.withBackoffLimits())
+	return nil
+}
+
+// runOnce calls run and turns a panic into panicError, so the goroutine returns instead of crashing
+func runOnce(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: string(debug.Stack())}
+		}
+	}()
+	return run(ctx)
+}
+
+func (s *supervisor) update(name string, change func(st *childStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(s.children[name])
+}
+
+func (s *supervisor) supervise(spec childSpec) {
+	defer s.wg.Done()
+	delay := spec.backoff
+	for restarts := 0; ; restarts++ {
+		err := runOnce(s.ctx, spec.run)
+
+		var state childState
+		switch {
+		case s.ctx.Err() != nil:
+			state = stateStopped
+		case spec.policy == restartNever, spec.policy == restartOnFailure && err == nil:
+			state = stateCompleted
+			if err != nil {
+				state = stateFailed
+			}
+		case spec.maxRestarts > 0 && restarts >= spec.maxRestarts:
+			state = stateGaveUp
+		default:
+			state = stateRestarting
+		}
+		s.update(spec.name, func(st *childStatus) {
+			st.state = state
+			if err != nil {
+				st.lastError = err
+				var pe *panicError
+				if errors.As(err, &pe) {
+					st.lastStack = pe.stack
+				}
+			}
+		})
+		if err != nil && state != stateStopped {
+			fmt.Printf("supervisor: %s %v, %s\n", spec.name, err, state)
+		}
+		if state != stateRestarting {
+			return
+		}
+
+		select {
+		case <-s.clock.After(delay):
+		case <-s.ctx.Done():
+			s.update(spec.name, func(st *childStatus) { st.state = stateStopped })
+			return
+		}
+		if delay > spec.maxBackoff/2 { //doubling would pass the cap, or overflow for a huge cap
+			delay = spec.maxBackoff
+		} else {
+			delay *= 2
+		}
+		s.update(spec.name, func(st *childStatus) {
+			st.state = stateRunning
+			st.restarts++
+			st.startedAt = s.clock.Now()
+		})
+	}
+}
+
+// snapshot is a copy, caller can keep it while goroutines go on changing
+func (s *supervisor) snapshot() []childStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make([]childStatus, 0, len(s.children))
+	for _, st := range s.children {
+		all = append(all, *st)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
+
+// stop cancels every goroutine and waits till they return
+func (s *supervisor) stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func printSnapshot(all []childStatus) {
+	for _, st := range all {
+		fmt.Printf("  %-8s policy=%-10s state=%-10s restarts=%d error=%v\n", st.name, st.policy, st.state, st.restarts, st.lastError)
+	}
+}
+
+func supervisorDemo() {
+	fmt.Println("+++++SUPERVISOR+++++")
+	clock := realClock{}
+	sup := newSupervisor(context.Background(), clock)
+
+	for i := 0; i <= 2; i++ {
+		sup.start(childSpec{name: fmt.Sprintf("task-%d", i), policy: restartNever, run: func(ctx context.Context) error {
+			task(i)
+			return nil
+		}})
+	}
+
+	flaky := 0
+	sup.start(childSpec{name: "flaky", policy: restartOnFailure, backoff: 10 * time.Millisecond, run: func(ctx context.Context) error {
+		if flaky++; flaky <= 2 {
+			panic(fmt.Sprintf("flaky crash %d", flaky))
+		}
+		return nil
+	}})
+	sup.start(childSpec{name: "crasher", policy: restartOnFailure, maxRestarts: 3, backoff: 10 * time.Millisecond, maxBackoff: 30 * time.Millisecond, run: func(ctx context.Context) error {
+		var tasks []int
+		return fmt.Errorf("never gets here %d", tasks[5]) //index out of range panic
+	}})
+	sup.start(childSpec{name: "ticker", policy: restartAlways, backoff: 10 * time.Millisecond, run: func(ctx context.Context) error {
+		select {
+		case <-clock.After(50 * time.Millisecond): //returns normally, always policy starts it again
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}})
+	fmt.Println("duplicate name:", sup.start(childSpec{name: "ticker"}))
+
+	clock.Sleep(300 * time.Millisecond)
+	printSnapshot(sup.snapshot())
+
+	sup.stop()
+	fmt.Println("after stop:")
+	all := sup.snapshot()
+	printSnapshot(all)
+	for _, st := range all {
+		if st.name == "crasher" {
+			fmt.Println("crasher stack starts with:", strings.SplitN(st.lastStack, "\n", 2)[0])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChildSpecBackoffLimits(t *testing.T) {
+	tests := []struct {
+		name           string
+		backoff        time.Duration
+		maxBackoff     time.Duration
+		wantBackoff    time.Duration
+		wantMaxBackoff time.Duration
+	}{
+		{"zero backoff", 0, 0, minRestartBackoff, maxRestartBackoff},
+		{"negative backoff", -time.Second, 0, minRestartBackoff, maxRestartBackoff},
+		{"kept as given", 50 * time.Millisecond, time.Second, 50 * time.Millisecond, time.Second},
+		{"backoff over the cap", time.Second, 100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"cap under the minimum", 0, time.Millisecond, minRestartBackoff, minRestartBackoff},
+		{"huge cap", time.Second, math.MaxInt64, time.Second, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := childSpec{backoff: tt.backoff, maxBackoff: tt.maxBackoff}.withBackoffLimits()
+			if got.backoff != tt.wantBackoff || got.maxBackoff != tt.wantMaxBackoff {
+				t.Errorf("backoff=%v maxBackoff=%v, want %v and %v", got.backoff, got.maxBackoff, tt.wantBackoff, tt.wantMaxBackoff)
+			}
+		})
+	}
+}
+
+// waitUntil polls cond, the supervised goroutines run on real time even when the clock is fake
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waiting tells how many After/Sleep calls are still waiting on the fake clock
+func (c *fakeClock) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// a child which returns at once with no backoff must not restart before the backoff is over
+func TestSupervisorDoesNotSpin(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	sup := newSupervisor(context.Background(), fake)
+	defer sup.stop()
+	var runs atomic.Int32
+	sup.start(childSpec{name: "fast", policy: restartAlways, run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+	waitUntil(t, "restart backoff", func() bool { return fake.waiting() == 1 })
+	time.Sleep(10 * time.Millisecond) //real time passing must not matter
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("child ran %d times before the backoff was over, want 1", n)
+	}
+	fake.Advance(minRestartBackoff)
+	waitUntil(t, "second run", func() bool { return runs.Load() == 2 })
+}
+
+// backoff doubles after every restart and stays at maxBackoff
+func TestSupervisorBackoffDoublesUpToCap(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	fake := newFakeClock(start)
+	sup := newSupervisor(context.Background(), fake)
+	defer sup.stop()
+	var runs atomic.Int32
+	sup.start(childSpec{name: "crasher", policy: restartOnFailure, backoff: 10 * time.Millisecond, maxBackoff: 30 * time.Millisecond,
+		run: func(ctx context.Context) error {
+			runs.Add(1)
+			return errors.New("boom")
+		}})
+
+	for i, delay := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond} {
+		waitUntil(t, "restart backoff", func() bool { return fake.waiting() == 1 })
+		fake.Advance(delay - time.Millisecond)
+		if n := runs.Load(); n != int32(i+1) {
+			t.Fatalf("restart %d came before %v, runs = %d", i+1, delay, n)
+		}
+		fake.Advance(time.Millisecond)
+		waitUntil(t, "restart", func() bool { return runs.Load() == int32(i+2) })
+	}
+	waitUntil(t, "status update", func() bool {
+		st := sup.snapshot()[0]
+		return st.restarts == 4 && st.startedAt.Equal(start.Add(90*time.Millisecond))
+	})
+}
@@ -32,4 +32,6 @@ func main() {
 
 	//This line blocks the main goroutine until all other goroutines finish their work (i.e., counter = 0).
 	wg.Wait()
+
+	workerPoolDemo()
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"learngo/internal/workerpool"
+)
+
+/*
+go task(i, &wg) starts one goroutine per task, 10k tasks -> 10k goroutines at once, and task can not give back result or error
+workerpool.Pool[T] (internal/workerpool, generic like 20_generic) fixes it:
+- at most Workers tasks run together, Submit waits for a free slot
+- Wait() waits like wg.Wait() but also gives results and the first error
+- FailFast, TaskTimeout and panic recovery, see the package for details
+it lives in its own package so other lessons can import it too
+*/
+
+func workerPoolDemo() {
+	fmt.Println("+++++WORKER POOL+++++")
+	square := func(n int) func(ctx context.Context) (int, error) {
+		return func(ctx context.Context) (int, error) {
+			select {
+			case <-time.After(time.Duration(10-n) * 5 * time.Millisecond): //later tasks finish first
+				return n * n, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	}
+
+	pool := workerpool.New[int](context.Background(), workerpool.Options{Workers: 3, Ordered: true})
+	for i := 0; i <= 10; i++ {
+		pool.Submit(square(i))
+	}
+	results, err := pool.Wait()
+	for _, r := range results {
+		fmt.Print(r.Value, " ")
+	}
+	fmt.Println("error:", err)
+
+	//panic and timeout become errors, other tasks still finish
+	pool = workerpool.New[int](context.Background(), workerpool.Options{Workers: 2, TaskTimeout: 20 * time.Millisecond})
+	pool.Submit(square(9))
+	pool.Submit(func(ctx context.Context) (int, error) {
+		var m map[string]int
+		m["boom"] = 1 //nil map write panics
+		return 0, nil
+	})
+	pool.Submit(square(0)) //takes 50ms, more than the timeout
+	results, err = pool.Wait()
+	for _, r := range results {
+		var pe *workerpool.PanicError
+		fmt.Println("task", r.Index, "value", r.Value, "error:", r.Err, "panic:", errors.As(r.Err, &pe))
+	}
+	fmt.Println("first error:", err)
+
+	//fail fast, tasks after the failure see cancelled ctx and stop
+	pool = workerpool.New[int](context.Background(), workerpool.Options{Workers: 2, FailFast: true})
+	pool.Submit(func(ctx context.Context) (int, error) { return 0, errors.New("bad input") })
+	for i := 0; i < 5; i++ {
+		pool.Submit(square(i))
+	}
+	results, err = pool.Wait()
+	cancelled := 0
+	for _, r := range results {
+		if errors.Is(r.Err, context.Canceled) {
+			cancelled++
+		}
+	}
+	fmt.Println("fail fast error:", err, "cancelled tasks:", cancelled, "of", len(results))
+}
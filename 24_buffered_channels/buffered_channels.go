@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -12,15 +17,61 @@ import (
 // closing buffered channel is so important otherwise it will go in deadlock after all execution
 // real world example is queue system like email sending
 
-func emailSender(emailChan <-chan string, done chan bool) { //<-chan means only can recieve the message
-	defer func() { done <- true }() //inline function
-	for email := range emailChan {
-		fmt.Println("sending email to:", email)
-		time.Sleep(time.Second)
+func emailSender(ctx context.Context, worker int, emailChan <-chan string, pool *emailPool) { //<-chan means only can recieve the message
+	for {
+		select {
+		case <-ctx.Done(): //drain deadline is over, leave the rest in channel
+			return
+		case email, ok := <-emailChan:
+			if !ok { //closed and empty
+				return
+			}
+			err := pool.deliver(ctx, worker, email)
+			if err != nil && ctx.Err() == nil { //cancelled ones are reported as abandoned
+				fmt.Println("failed email to:", email, "error:", err)
+			}
+			pool.record(worker, email, err)
+		}
 	}
 }
 
+const emailJournal = "emails.journal"
+
 func main() {
+	//go run . replay-dead -> put dead letters back to the queue
+	if len(os.Args) > 1 && os.Args[1] == "replay-dead" {
+		q, err := openEmailQueue(emailJournal, defaultEmailQueueOptions())
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		defer q.Close()
+		n, err := q.replayDeadLetters()
+		fmt.Println("re-enqueued", n, "dead letters, error:", err)
+		return
+	}
+	//go run . preview [dir] -> render every email template into files
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		dir := "previews"
+		if len(os.Args) > 2 {
+			dir = os.Args[2]
+		}
+		engine, err := newTemplateEngine("shop@example.com")
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		files, err := previewTemplates(engine, dir, sampleTemplateData(realClock{}), realClock{})
+		for _, f := range files {
+			fmt.Println("wrote", f)
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	emailChan := make(chan string, 100) // here 100 is the size
 	emailChan <- "1@example.com"
 	emailChan <- "2@example.com"
@@ -30,9 +81,61 @@ func main() {
 	fmt.Println(<-emailChan)
 
 	emailChanBulk := make(chan string, 100) //buffered channel
-	done := make(chan bool)                 //for wait_group
 
-	go emailSender(emailChanBulk, done) //creating go routine
+	//demo files (maildir, email journal) go to a temp dir, running the lesson leaves nothing behind
+	demoDir, err := os.MkdirTemp("", "buffered-channels-*")
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(demoDir)
+
+	//MAIL_BACKEND=smtp sends for real, default writes the mails into a maildir (MAILDIR to keep them)
+	mailer, err := newMailerFromEnv(realClock{}, filepath.Join(demoDir, "maildir"))
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	engine, err := newTemplateEngine("shop@example.com")
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	//every address gets its own name and order in the mail, map is filled before workers start so no locking needed
+	recipients := map[string]templateData{}
+	for i := 0; i < 10; i++ {
+		email := fmt.Sprintf("%d@gmail.com", i)
+		recipients[email] = personalize(
+			emailRecipient{Email: email, Name: fmt.Sprintf("Customer %d", i)},
+			orderDetails{ID: fmt.Sprintf("ORD-%d", 1000+i), Status: Confrimed, Items: []orderItem{{Name: "Notebook", Qty: i + 1, Price: "₹60.00"}}, Total: fmt.Sprintf("₹%d.00", 60*(i+1))},
+			map[string]string{"coupon": "WELCOME10"},
+		)
+	}
+
+	//ctrl+c or SIGTERM cancels ctx -> pool drains what is queued, then stops
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	limiter, err := newRateLimiter(defaultRateLimitConfig(), realClock{})
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	pool := newEmailPool(emailPoolOptions{
+		workers:      workersFromEnv(3),
+		drainTimeout: 5 * time.Second,
+		limiter:      limiter,
+		mailer:       mailer,
+		render: func(to string) (emailMessage, error) {
+			data, ok := recipients[to]
+			if !ok {
+				return emailMessage{}, fmt.Errorf("no order details for %s", to) //zero data would mail nobody
+			}
+			return engine.render("order_confirmation", data)
+		},
+	})
+	reports := make(chan shutdownReport, 1)                 //replaces done chan bool, carries the result too
+	go func() { reports <- pool.run(ctx, emailChanBulk) }() //creating go routine, it starts the workers
 
 	//this will not block now i mean not wait for recieve because of buffer its process the sending and then email sender works
 	for i := 0; i < 10; i++ {
@@ -42,7 +145,13 @@ func main() {
 	//print emediatly because it will in go routine now and channel is not blocking because of buffer
 	fmt.Println("done sending!")
 
-	close(emailChanBulk) //closing the channel -> preventing deadlock becaude range in email sender will loop infinite and done always will wait
+	close(emailChanBulk) //closing the channel -> preventing deadlock becaude workers will wait for more emails forever
+
+	report := <-reports //should not exit till pool is finished, all workers returned
+	fmt.Println("shutdown report:", report)
+	fmt.Println("rate limit:", limiter.snapshot())
 
-	<-done //should not exit till we recieve done, and it will come only and only when all operations execute in emailSender
+	mailerDemo()
+	templatesDemo()
+	emailQueueDemo(demoDir)
 }
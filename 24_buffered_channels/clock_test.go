@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockSleepAndTicker(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	fake := newFakeClock(start)
+
+	woke := make(chan struct{})
+	go func() {
+		fake.Sleep(time.Second)
+		close(woke)
+	}()
+	waitUntil(t, "sleep to start", func() bool { return fake.waiting() == 1 })
+
+	ticker := fake.NewTicker(300 * time.Millisecond)
+	fake.Advance(time.Second) //three ticks are due, the reader is slow so only the first is kept
+	<-woke
+	if got := <-ticker.C(); !got.Equal(start.Add(300 * time.Millisecond)) {
+		t.Errorf("tick at %v, want the first one", got)
+	}
+	select {
+	case got := <-ticker.C():
+		t.Errorf("missed ticks were queued: %v", got)
+	default:
+	}
+
+	fake.Advance(200 * time.Millisecond) //next tick was at 1.2s
+	if got := <-ticker.C(); !got.Equal(start.Add(1200 * time.Millisecond)) {
+		t.Errorf("tick at %v, want 1.2s after start", got)
+	}
+	ticker.Stop()
+	fake.Advance(time.Second)
+	select {
+	case got := <-ticker.C():
+		t.Errorf("stopped ticker fired at %v", got)
+	default:
+	}
+}
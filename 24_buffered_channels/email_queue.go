@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"learngo/internal/jsonl"
+)
+
+/*
+buffered channel lives in memory, if the program crashes every email waiting in it is lost
+emailQueue writes every change to a journal file first (one JSON line per change) and replays it on start
+- enqueue -> job is pending
+- next -> worker takes the oldest job which is due, it stays in the journal till ack
+- ack -> sent, job is done
+- fail -> attempts++ and job waits base*2^attempts before next try (exponential backoff)
+  after maxAttempts or on a permanent error (bad address) the job goes to the dead letter queue
+- replayDeadLetters -> ops fixed the problem, put dead jobs back as fresh pending jobs
+a job taken by next but never acked (crash while sending) is pending again after restart -> at least once delivery
+every sent email leaves lines behind, so when the journal has compactSlack more lines than live jobs
+it is rewritten with one line per pending or dead job (also on open), a torn last line from a crash is cut off
+*/
+
+const compactSlack = 100
+
+var (
+	errPermanentFailure = errors.New("permanent delivery failure") //wrap this when retrying can never help
+	errJobNotFound      = errors.New("email job not found")
+	errQueueClosed      = errors.New("email queue closed")
+)
+
+type emailJob struct {
+	ID          string    `json:"id"`
+	To          string    `json:"to"`
+	Subject     string    `json:"subject,omitempty"`
+	Body        string    `json:"body,omitempty"`
+	Attempts    int       `json:"attempts"`
+	EnqueuedAt  time.Time `json:"enqueuedAt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// one line in the journal
+type emailJournalEntry struct {
+	Op  string    `json:"op"` //enqueue, sent, retry, dead, replay
+	Job *emailJob `json:"job,omitempty"`
+	ID  string    `json:"id,omitempty"`
+}
+
+type emailQueueOptions struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration //0 -> no cap
+	clock       Clock
+	onError     func(error) //problems which do not fail the call (journal compaction), nil -> dropped
+}
+
+func defaultEmailQueueOptions() emailQueueOptions {
+	return emailQueueOptions{maxAttempts: 5, baseDelay: time.Second, maxDelay: 10 * time.Minute, clock: realClock{}}
+}
+
+type emailQueue struct {
+	opts emailQueueOptions
+
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	lines    int //lines in the journal, compared with live jobs to know when to compact
+	pending  map[string]*emailJob
+	inFlight map[string]bool
+	dead     map[string]*emailJob
+	wake     chan struct{} //poked on every change so waiting next() looks again
+	closed   bool
+}
+
+func openEmailQueue(path string, opts emailQueueOptions) (*emailQueue, error) {
+	if opts.clock == nil {
+		opts.clock = realClock{}
+	}
+	if opts.maxAttempts < 1 {
+		opts.maxAttempts = 1
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening email queue: %w", err)
+	}
+	q := &emailQueue{
+		opts:     opts,
+		path:     path,
+		pending:  map[string]*emailJob{},
+		inFlight: map[string]bool{},
+		dead:     map[string]*emailJob{},
+		wake:     make(chan struct{}, 1),
+	}
+	err = jsonl.Replay(f, func(line []byte) error {
+		var entry emailJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		q.lines++
+		return q.apply(entry)
+	})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading email queue %s: %w", path, err)
+	}
+	q.file = f
+	if q.lines > len(q.pending)+len(q.dead) {
+		if err := q.compact(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// apply changes the in memory state, used for replay and after every journal write
+func (q *emailQueue) apply(entry emailJournalEntry) error {
+	if entry.Op != "sent" && entry.Job == nil {
+		return fmt.Errorf("%s entry without job", entry.Op)
+	}
+	switch entry.Op {
+	case "enqueue", "retry":
+		job := *entry.Job
+		q.pending[job.ID] = &job
+	case "sent":
+		delete(q.pending, entry.ID)
+	case "dead":
+		job := *entry.Job
+		delete(q.pending, job.ID)
+		q.dead[job.ID] = &job
+	case "replay":
+		job := *entry.Job
+		delete(q.dead, job.ID)
+		q.pending[job.ID] = &job
+	}
+	return nil
+}
+
+// compact rewrites the journal with only the live jobs, caller holds the lock (or is still opening)
+func (q *emailQueue) compact() error {
+	write := func(w io.Writer, op string, jobs map[string]*emailJob) error {
+		ids := make([]string, 0, len(jobs))
+		for id := range jobs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			line, err := json.Marshal(emailJournalEntry{Op: op, Job: jobs[id]})
+			if err != nil {
+				return err
+			}
+			if err := jsonl.Append(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := jsonl.Compact(q.path, func(w io.Writer) error {
+		if err := write(w, "enqueue", q.pending); err != nil {
+			return err
+		}
+		return write(w, "dead", q.dead)
+	})
+	if err != nil {
+		return fmt.Errorf("compacting email journal: %w", err)
+	}
+	f, err := os.OpenFile(q.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopening email journal: %w", err)
+	}
+	q.file.Close()
+	q.file = f
+	q.lines = len(q.pending) + len(q.dead)
+	return nil
+}
+
+// record writes to the journal first and only then changes memory, caller holds the lock
+func (q *emailQueue) record(entry emailJournalEntry) error {
+	if q.closed {
+		return errQueueClosed
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := jsonl.Append(q.file, line); err != nil {
+		return fmt.Errorf("writing email journal: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("syncing email journal: %w", err)
+	}
+	q.lines++
+	q.apply(entry)
+	if q.lines > len(q.pending)+len(q.dead)+compactSlack {
+		if err := q.compact(); err != nil && q.opts.onError != nil { //the entry is already safe in the old journal, just report it
+			q.opts.onError(err)
+		}
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default: //someone will wake up already
+	}
+	return nil
+}
+
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (q *emailQueue) enqueue(to string, subject string, body string) (emailJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.opts.clock.Now()
+	job := emailJob{ID: newJobID(), To: to, Subject: subject, Body: body, EnqueuedAt: now, NextAttempt: now}
+	if err := q.record(emailJournalEntry{Op: "enqueue", Job: &job}); err != nil {
+		return emailJob{}, err
+	}
+	return job, nil
+}
+
+// next blocks until a job is due, jobs come oldest first
+func (q *emailQueue) next(ctx context.Context) (emailJob, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return emailJob{}, errQueueClosed
+		}
+		job, wait := q.due()
+		if job != nil {
+			q.inFlight[job.ID] = true
+			taken := *job
+			q.mu.Unlock()
+			return taken, nil
+		}
+		q.mu.Unlock()
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = q.opts.clock.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return emailJob{}, ctx.Err()
+		case <-q.wake:
+		case <-timer:
+		}
+	}
+}
+
+// due finds the oldest due job, or how long till the first one becomes due (0 = nothing pending), caller holds the lock
+func (q *emailQueue) due() (*emailJob, time.Duration) {
+	now := q.opts.clock.Now()
+	var ready []*emailJob
+	var soonest time.Time
+	for id, job := range q.pending {
+		if q.inFlight[id] {
+			continue
+		}
+		if !job.NextAttempt.After(now) {
+			ready = append(ready, job)
+		} else if soonest.IsZero() || job.NextAttempt.Before(soonest) {
+			soonest = job.NextAttempt
+		}
+	}
+	if len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].EnqueuedAt.Before(ready[j].EnqueuedAt) })
+		return ready[0], 0
+	}
+	if soonest.IsZero() {
+		return nil, 0
+	}
+	return nil, soonest.Sub(now)
+}
+
+func (q *emailQueue) ack(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[id]; !ok {
+		return fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	delete(q.inFlight, id)
+	return q.record(emailJournalEntry{Op: "sent", ID: id})
+}
+
+func (q *emailQueue) backoff(attempts int) time.Duration {
+	delay := q.opts.baseDelay
+	for i := 1; i < attempts; i++ {
+		if (q.opts.maxDelay > 0 && delay >= q.opts.maxDelay) || delay > math.MaxInt64/2 { //no cap still can not go past int64
+			break
+		}
+		delay *= 2
+	}
+	if q.opts.maxDelay > 0 && delay > q.opts.maxDelay {
+		delay = q.opts.maxDelay
+	}
+	return delay
+}
+
+// fail schedules a retry, or dead letters the job when it is permanent or out of attempts, returns true if dead
+func (q *emailQueue) fail(id string, cause error) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	current, ok := q.pending[id]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", errJobNotFound, id)
+	}
+	delete(q.inFlight, id)
+	job := *current
+	job.Attempts++
+	job.LastError = cause.Error()
+	if errors.Is(cause, errPermanentFailure) || job.Attempts >= q.opts.maxAttempts {
+		return true, q.record(emailJournalEntry{Op: "dead", Job: &job})
+	}
+	job.NextAttempt = q.opts.clock.Now().Add(q.backoff(job.Attempts))
+	return false, q.record(emailJournalEntry{Op: "retry", Job: &job})
+}
+
+// release puts an in flight job back without counting an attempt (worker stopped before sending)
+func (q *emailQueue) release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, id)
+	if q.closed {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *emailQueue) deadLetters() []emailJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]emailJob, 0, len(q.dead))
+	for _, job := range q.dead {
+		out = append(out, *job)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].EnqueuedAt.Before(out[j].EnqueuedAt) })
+	return out
+}
+
+// replayDeadLetters moves every dead job back to pending with attempts reset
+func (q *emailQueue) replayDeadLetters() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]string, 0, len(q.dead))
+	for id := range q.dead {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for i, id := range ids {
+		job := *q.dead[id]
+		job.Attempts = 0
+		job.LastError = ""
+		job.NextAttempt = q.opts.clock.Now()
+		if err := q.record(emailJournalEntry{Op: "replay", Job: &job}); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (q *emailQueue) pendingCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *emailQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.wake) //wakes every next() so they see closed
+	return q.file.Close()
+}
+
+/*
+durableEmailSender is emailSender for the queue, deliver does the actual sending
+retries go through the same limiter as the pool, so a retry storm can not go over the domain limits
+limiter can be nil -> no limit
+returns nil when ctx is cancelled or the queue is closed, and an error when a result could not be written to the journal:
+the email is still pending there and is sent again after restart, caller has to know the journal is broken
+*/
+func durableEmailSender(ctx context.Context, q *emailQueue, limiter *rateLimiter, deliver func(emailJob) error) error {
+	for {
+		job, err := q.next(ctx)
+		if err != nil {
+			return nil //cancelled or closed
+		}
+		if limiter != nil {
+			if err := limiter.wait(ctx, job.To); err != nil {
+				q.release(job.ID) //not sent, not failed, next run picks it up again
+				return nil
+			}
+		}
+		if err := deliver(job); err != nil {
+			dead, failErr := q.fail(job.ID, err)
+			if failErr != nil {
+				return fmt.Errorf("recording failed email %s: %w", job.ID, failErr)
+			}
+			fmt.Println("failed email to:", job.To, "attempt", job.Attempts+1, "dead:", dead, "error:", err)
+			continue
+		}
+		if err := q.ack(job.ID); err != nil {
+			return fmt.Errorf("recording sent email %s: %w", job.ID, err)
+		}
+		fmt.Println("sent email to:", job.To)
+	}
+}
+
+func emailQueueDemo(dir string) {
+	fmt.Println("+++++DURABLE EMAIL QUEUE+++++")
+	path := filepath.Join(dir, emailJournal)
+	opts := defaultEmailQueueOptions()
+	opts.baseDelay = 10 * time.Millisecond
+	opts.maxAttempts = 3
+	opts.onError = func(err error) { fmt.Println("email journal:", err) }
+	q, err := openEmailQueue(path, opts)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer q.Close()
+
+	q.enqueue("1@gmail.com", "hello", "hi")
+	q.enqueue("bounce@invalid", "hello", "hi")
+	q.enqueue("flaky@gmail.com", "hello", "hi")
+
+	//real SMTP conversation with the in-process fake server, it rejects bounce@invalid with 550
+	server, err := startFakeSMTPServer("", "")
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer server.Close()
+	server.rejectRecipient("bounce@invalid")
+	host, port := server.addr()
+	send := mailerDelivery(smtpMailer{host: host, port: port, insecure: true}, "shop@example.com")
+
+	flaky := 0
+	deliver := func(job emailJob) error {
+		if job.To == "flaky@gmail.com" {
+			if flaky++; flaky < 3 {
+				return errors.New("connection reset")
+			}
+		}
+		return send(job) //550 comes back as errPermanentFailure -> dead letter at once
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	limiter, err := newRateLimiter(defaultRateLimitConfig(), opts.clock)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if err := durableEmailSender(ctx, q, limiter, deliver); err != nil { //runs till the timeout
+		fmt.Println("error:", err)
+	}
+
+	for _, job := range q.deadLetters() {
+		fmt.Println("dead letter:", job.To, job.LastError)
+	}
+	fmt.Println("pending:", q.pendingCount())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailQueueCompactsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), emailJournal)
+	q, err := openEmailQueue(path, defaultEmailQueueOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactSlack; i++ { //every email is 2 lines (enqueue + sent)
+		job, err := q.enqueue("1@gmail.com", "hello", "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.next(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.ack(job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept, _ := q.enqueue("2@gmail.com", "still here", "hi")
+	q.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > compactSlack+1 {
+		t.Errorf("journal has %d lines for 1 live job, it was not compacted", lines)
+	}
+
+	reopened, err := openEmailQueue(path, defaultEmailQueueOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	job, err := reopened.next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != kept.ID || reopened.pendingCount() != 1 {
+		t.Errorf("after reopen next = %s (%d pending), want %s only", job.ID, reopened.pendingCount(), kept.ID)
+	}
+}
+
+func TestEmailQueueSurvivesTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), emailJournal)
+	q, err := openEmailQueue(path, defaultEmailQueueOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.enqueue("1@gmail.com", "hello", "hi")
+	q.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"enqueue","job":{"id":"ab`)
+	f.Close()
+
+	reopened, err := openEmailQueue(path, defaultEmailQueueOptions())
+	if err != nil {
+		t.Fatalf("reopen with torn line: %v", err)
+	}
+	defer reopened.Close()
+	if n := reopened.pendingCount(); n != 1 {
+		t.Errorf("pending = %d, want 1", n)
+	}
+	if _, err := reopened.enqueue("2@gmail.com", "hello", "hi"); err != nil {
+		t.Fatalf("enqueue after torn line: %v", err)
+	}
+}
+
+func TestEmailQueueBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDelay time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{"first retry", 10 * time.Minute, 1, time.Second},
+		{"doubles", 10 * time.Minute, 4, 8 * time.Second},
+		{"capped", 10 * time.Minute, 11, 10 * time.Minute},
+		{"capped far out", 10 * time.Minute, 1000, 10 * time.Minute},
+		{"no cap keeps doubling", 0, 4, 8 * time.Second},
+		{"no cap past ten minutes", 0, 11, 1024 * time.Second},
+		{"no cap does not overflow", 0, 1000, time.Second << 33}, //last doubling under math.MaxInt64
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &emailQueue{opts: emailQueueOptions{baseDelay: time.Second, maxDelay: tt.maxDelay}}
+			if got := q.backoff(tt.attempts); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailQueueReportsCompactionError(t *testing.T) {
+	dir := t.TempDir()
+	var reported []error
+	opts := defaultEmailQueueOptions()
+	opts.onError = func(err error) { reported = append(reported, err) }
+	q, err := openEmailQueue(filepath.Join(dir, emailJournal), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	os.RemoveAll(dir) //open journal still takes writes, the compacted copy can not be created
+
+	for i := 0; i < compactSlack; i++ {
+		job, err := q.enqueue("1@gmail.com", "hello", "hi")
+		if err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+		q.next(context.Background())
+		if err := q.ack(job.ID); err != nil {
+			t.Fatalf("ack %d: %v", i, err) //the entry itself was written, only compaction failed
+		}
+	}
+	if len(reported) == 0 {
+		t.Fatal("compaction failure was not reported")
+	}
+	if !strings.Contains(reported[0].Error(), "compacting email journal") {
+		t.Errorf("reported %v, want the compaction error", reported[0])
+	}
+}
+
+// the journal can not take the result -> the sender stops and says so instead of dropping it
+func TestDurableEmailSenderReturnsJournalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		deliver error
+		want    string
+	}{
+		{"ack", nil, "recording sent email"},
+		{"fail", errors.New("connection reset"), "recording failed email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := openEmailQueue(filepath.Join(t.TempDir(), emailJournal), defaultEmailQueueOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.enqueue("1@gmail.com", "hello", "hi")
+			err = durableEmailSender(context.Background(), q, nil, func(job emailJob) error {
+				q.Close() //journal goes away while the email is being sent
+				return tt.deliver
+			})
+			if !errors.Is(err, errQueueClosed) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q wrapping errQueueClosed", err, tt.want)
+			}
+		})
+	}
+
+	//closed or cancelled while waiting is a normal stop
+	q, err := openEmailQueue(filepath.Join(t.TempDir(), emailJournal), defaultEmailQueueOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if err := durableEmailSender(context.Background(), q, nil, func(emailJob) error { return nil }); err != nil {
+		t.Errorf("closed queue err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+/*
+fakeSMTPServer speaks just enough SMTP for net/smtp client: EHLO, AUTH PLAIN, MAIL, RCPT, DATA, RSET, NOOP, QUIT
+it keeps every received message in memory so tests can check what was sent
+no STARTTLS, so smtpMailer must be used with insecure: true against it
+*/
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+type fakeSMTPServer struct {
+	listener net.Listener
+	username string //empty -> AUTH not required
+	password string
+
+	mu       sync.Mutex
+	reject   map[string]bool //recipients answered with 550, set with rejectRecipient
+	messages []receivedMail
+	wg       sync.WaitGroup
+}
+
+func startFakeSMTPServer(username string, password string) (*fakeSMTPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0") //port 0 -> OS gives a free port
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeSMTPServer{listener: l, username: username, password: password, reject: map[string]bool{}}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeSMTPServer) addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+// rejectRecipient makes RCPT TO:<address> fail with 550, safe while connections are being handled
+func (s *fakeSMTPServer) rejectRecipient(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject[address] = true
+}
+
+func (s *fakeSMTPServer) rejects(address string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reject[address]
+}
+
+func (s *fakeSMTPServer) received() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return //listener closed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake-smtp ready")
+	authed := s.username == ""
+	var current receivedMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-fake-smtp")
+			reply("250-AUTH PLAIN")
+			reply("250 8BITMIME")
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			creds, _ := base64.StdEncoding.DecodeString(initial)
+			parts := strings.Split(string(creds), "\x00") //authzid \0 user \0 password
+			if strings.ToUpper(mech) == "PLAIN" && len(parts) == 3 && parts[1] == s.username && parts[2] == s.password {
+				authed = true
+				reply("235 authenticated")
+			} else {
+				reply("535 authentication failed")
+			}
+		case "MAIL":
+			if !authed {
+				reply("530 authentication required")
+				continue
+			}
+			current = receivedMail{from: addressArg(arg)}
+			reply("250 ok")
+		case "RCPT":
+			to := addressArg(arg)
+			if s.rejects(to) {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			current.to = append(current.to, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" || l == ".\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, ".")) //dot stuffing
+			}
+			current.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, current)
+			s.mu.Unlock()
+			current = receivedMail{}
+			reply("250 queued")
+		case "RSET":
+			current = receivedMail{}
+			reply("250 ok")
+		case "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// "FROM:<a@b.com> BODY=8BITMIME" -> a@b.com
+func addressArg(arg string) string {
+	_, rest, _ := strings.Cut(arg, ":")
+	rest = strings.TrimSpace(rest)
+	if end := strings.Index(rest, ">"); end >= 0 {
+		rest = rest[:end]
+	}
+	return strings.TrimPrefix(rest, "<")
+}
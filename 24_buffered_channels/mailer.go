@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+emailSender was only printing, now it gives the message to a Mailer (interface, like paymenter in 18_interfaces)
+- smtpMailer -> real SMTP server, STARTTLS + AUTH PLAIN
+- maildirMailer -> writes .eml files into a maildir folder, for local dev, open them in any mail client
+- fakeSMTPServer (fake_smtp.go) -> tiny SMTP server inside the program for tests
+*/
+
+type emailMessage struct {
+	from     string
+	to       []string
+	subject  string
+	textBody string
+	htmlBody string //not empty -> multipart/alternative with both parts
+}
+
+type Mailer interface {
+	send(ctx context.Context, msg emailMessage) error
+}
+
+// buildMessage makes the raw RFC 5322 message (headers + quoted-printable body, text and html as multipart/alternative)
+func buildMessage(msg emailMessage, now time.Time) []byte {
+	var b bytes.Buffer
+	header := func(k string, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+	header("From", msg.from)
+	header("To", strings.Join(msg.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.subject)) //non ascii subject must be encoded
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+randomToken()+"@"+domainOf(msg.from)+">")
+	header("MIME-Version", "1.0")
+
+	if msg.htmlBody == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		writeQuotedPrintable(&b, msg.textBody)
+		return b.Bytes()
+	}
+
+	//plain text first, html last: clients show the last part they understand
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.textBody},
+		{"text/html; charset=utf-8", msg.htmlBody},
+	} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(w, part.body)
+	}
+	mw.Close()
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	b.WriteString("\r\n")
+	b.Write(parts.Bytes())
+	return b.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(body))
+	qp.Close()
+}
+
+func randomToken() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.Trim(address[at+1:], "> ")
+	}
+	return "localhost"
+}
+
+type smtpMailer struct {
+	host     string
+	port     string
+	username string //empty -> no AUTH
+	password string
+	insecure bool //allow sending without STARTTLS, only for local fake servers
+	timeout  time.Duration
+	clock    Clock
+}
+
+// 5xx from the server means the address or message is wrong, retrying will not help
+func classifySMTPError(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return fmt.Errorf("%w: %v", errPermanentFailure, err)
+	}
+	return err
+}
+
+func (m smtpMailer) send(ctx context.Context, msg emailMessage) error {
+	timeout := m.timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	clock := m.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, m.port))
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	//deadline only covers timeouts, a cancelled ctx (shutdown) must also stop a read that is waiting on the server
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = m.talk(conn, msg, clock)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return fmt.Errorf("smtp: %w", ctxErr) //the i/o error is only the result of closing the connection
+	}
+	return err
+}
+
+// talk is the SMTP conversation on an open connection
+func (m smtpMailer) talk(conn net.Conn, msg emailMessage, clock Clock) error {
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	} else if !m.insecure {
+		return fmt.Errorf("smtp server %s does not support STARTTLS", m.host)
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", classifySMTPError(err))
+		}
+	}
+
+	if err := c.Mail(msg.from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", classifySMTPError(err))
+	}
+	for _, to := range msg.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp rcpt %s: %w", to, classifySMTPError(err))
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", classifySMTPError(err))
+	}
+	if _, err := w.Write(buildMessage(msg, clock.Now())); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data end: %w", classifySMTPError(err))
+	}
+	return c.Quit()
+}
+
+/*
+maildir: message is written to tmp/ first and then renamed to new/
+rename is atomic, so a mail client never sees a half written file
+*/
+type maildirMailer struct {
+	dir   string
+	clock Clock
+}
+
+func newMaildirMailer(dir string, clock Clock) (*maildirMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("creating maildir: %w", err)
+		}
+	}
+	return &maildirMailer{dir: dir, clock: clock}, nil
+}
+
+func (m *maildirMailer) send(ctx context.Context, msg emailMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := m.clock.Now()
+	name := fmt.Sprintf("%d.%s.eml", now.UnixNano(), randomToken())
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, buildMessage(msg, now), 0o644); err != nil {
+		return fmt.Errorf("maildir write: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+/*
+newMailerFromEnv picks the backend from env so dev and prod run the same code
+MAIL_BACKEND=smtp (SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD) or maildir (MAILDIR, default defaultMaildir)
+*/
+func newMailerFromEnv(clock Clock, defaultMaildir string) (Mailer, error) {
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST is required for smtp mail backend")
+		}
+		return smtpMailer{host: host, port: port, username: os.Getenv("SMTP_USERNAME"), password: os.Getenv("SMTP_PASSWORD"), clock: clock}, nil
+	case "", "maildir":
+		dir := os.Getenv("MAILDIR")
+		if dir == "" {
+			dir = defaultMaildir
+		}
+		return newMaildirMailer(dir, clock)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q, use smtp or maildir", backend)
+	}
+}
+
+// mailerDelivery adapts a Mailer to the durable queue deliver func
+func mailerDelivery(m Mailer, from string) func(emailJob) error {
+	return func(job emailJob) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return m.send(ctx, emailMessage{from: from, to: []string{job.To}, subject: job.Subject, textBody: job.Body})
+	}
+}
+
+func mailerDemo() {
+	fmt.Println("+++++MAILER+++++")
+	server, err := startFakeSMTPServer("shop", "secret")
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer server.Close()
+	host, port := server.addr()
+
+	mailer := smtpMailer{host: host, port: port, username: "shop", password: "secret", insecure: true}
+	msg := emailMessage{from: "shop@example.com", to: []string{"1@gmail.com"}, subject: "Order confirmed ✔", textBody: "thanks for your order\n"}
+	fmt.Println("send:", mailer.send(context.Background(), msg))
+
+	wrongPassword := smtpMailer{host: host, port: port, username: "shop", password: "nope", insecure: true}
+	err = wrongPassword.send(context.Background(), msg)
+	fmt.Println("wrong password permanent:", errors.Is(err, errPermanentFailure), err)
+
+	for _, m := range server.received() {
+		fmt.Println("server got mail from", m.from, "to", m.to, "bytes", len(m.data))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSMTPMailerStopsOnCancel(t *testing.T) {
+	//server accepts and then says nothing, client waits for the 220 greeting
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	mailer := smtpMailer{host: host, port: port, insecure: true, timeout: time.Minute}
+
+	ctx, cancel := context.WithCancel(context.Background()) //no deadline, only cancel
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err = mailer.send(ctx, emailMessage{from: "shop@example.com", to: []string{"1@gmail.com"}, subject: "hi", textBody: "hi"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("send took %v after cancel, connection was not closed", took)
+	}
+}
+
+func TestFakeSMTPRejectRecipient(t *testing.T) {
+	server, err := startFakeSMTPServer("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	host, port := server.addr()
+	mailer := smtpMailer{host: host, port: port, insecure: true}
+	msg := emailMessage{from: "shop@example.com", to: []string{"bounce@invalid"}, subject: "hi", textBody: "hi"}
+
+	done := make(chan error, 1)
+	go func() { done <- mailer.send(context.Background(), msg) }()
+	server.rejectRecipient("bounce@invalid") //while the first send may be running, the race detector checks this
+	<-done
+
+	if err := mailer.send(context.Background(), msg); !errors.Is(err, errPermanentFailure) {
+		t.Errorf("send to rejected recipient = %v, want errPermanentFailure", err)
+	}
+}
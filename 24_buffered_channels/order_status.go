@@ -0,0 +1,25 @@
+package main
+
+import "fmt"
+
+// same OrderStatus enum as 19_enum (trimmed), shipping notices are picked by it, every lesson is its own main package so we declare it again here
+type OrderStatus int
+
+const (
+	Recieved OrderStatus = iota
+	Confrimed
+	Prepared
+	Shipped
+	Delivered
+	Cancelled
+	Returned
+)
+
+var orderStatusNames = []string{"Received", "Confirmed", "Prepared", "Shipped", "Delivered", "Cancelled", "Returned"}
+
+func (s OrderStatus) String() string {
+	if s < Recieved || s > Returned {
+		return fmt.Sprintf("OrderStatus(%d)", int(s))
+	}
+	return orderStatusNames[s]
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+token bucket: bucket holds up to burst tokens, refills perSecond tokens every second
+every email takes one token, empty bucket -> wait till next token comes
+two buckets must agree before sending:
+- global bucket -> our whole sending speed
+- domain bucket -> speed per recipient domain, gmail.com blocks faster than our own example.com
+old code slept 1 second after every email, now we only wait when a limit is really hit
+a full domain bucket is same as a new one, so idle buckets are dropped to keep the map small
+metrics are totals and can not be dropped like that, so only maxMetricDomains domains get their own line,
+the rest add up under otherDomains (configured domains always get their own), one line per spammer domain is not kept forever
+*/
+
+const (
+	bucketSweepEvery = time.Minute //how often wait looks for idle domain buckets
+	maxMetricDomains = 100
+	otherDomains     = "(other)"
+)
+
+var errInvalidRateLimit = errors.New("invalid rate limit")
+
+type rateLimit struct {
+	perSecond float64
+	burst     int
+}
+
+type rateLimitConfig struct {
+	global        rateLimit
+	defaultDomain rateLimit            //for domains not in the map
+	domains       map[string]rateLimit //"gmail.com" -> its own limit
+}
+
+func (r rateLimit) validate() error {
+	if r.perSecond <= 0 {
+		return fmt.Errorf("%w: perSecond must be > 0, got %v", errInvalidRateLimit, r.perSecond)
+	}
+	if r.burst < 1 {
+		return fmt.Errorf("%w: burst must be >= 1, got %d", errInvalidRateLimit, r.burst)
+	}
+	return nil
+}
+
+func (c rateLimitConfig) validate() error {
+	if err := c.global.validate(); err != nil {
+		return fmt.Errorf("global: %w", err)
+	}
+	if err := c.defaultDomain.validate(); err != nil {
+		return fmt.Errorf("default domain: %w", err)
+	}
+	for domain, limit := range c.domains {
+		if err := limit.validate(); err != nil {
+			return fmt.Errorf("%s: %w", domain, err)
+		}
+	}
+	return nil
+}
+
+func defaultRateLimitConfig() rateLimitConfig {
+	return rateLimitConfig{
+		global:        rateLimit{perSecond: 10, burst: 10},
+		defaultDomain: rateLimit{perSecond: 2, burst: 5},
+		domains: map[string]rateLimit{
+			"gmail.com":   {perSecond: 2, burst: 3},
+			"example.com": {perSecond: 20, burst: 20},
+		},
+	}
+}
+
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit rateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.limit.perSecond
+		if b.tokens > float64(b.limit.burst) {
+			b.tokens = float64(b.limit.burst)
+		}
+		b.last = now
+	}
+}
+
+// waitFor tells how long till one token is there, 0 -> can take now
+func (b *tokenBucket) waitFor(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.perSecond * float64(time.Second))
+}
+
+type domainMetrics struct {
+	sent      int
+	throttled int
+}
+
+type rateLimitMetrics struct {
+	queued    int //messages waiting for a token right now
+	sent      int
+	throttled int           //messages which had to wait at least once
+	waited    time.Duration //real time spent waiting, not the delays we planned
+	domains   map[string]domainMetrics
+}
+
+func (m rateLimitMetrics) String() string {
+	names := make([]string, 0, len(m.domains))
+	for d := range m.domains {
+		names = append(names, d)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "queued=%d sent=%d throttled=%d waited=%s", m.queued, m.sent, m.throttled, m.waited.Round(time.Millisecond))
+	for _, d := range names {
+		fmt.Fprintf(&b, " %s(sent=%d throttled=%d)", d, m.domains[d].sent, m.domains[d].throttled)
+	}
+	return b.String()
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	clock     Clock
+	config    rateLimitConfig
+	global    *tokenBucket
+	domains   map[string]*tokenBucket
+	lastSweep time.Time
+	metrics   rateLimitMetrics
+}
+
+// newRateLimiter fails for zero or negative rates and bursts, those buckets would never give a token
+func newRateLimiter(config rateLimitConfig, clock Clock) (*rateLimiter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	now := clock.Now()
+	return &rateLimiter{
+		clock:     clock,
+		config:    config,
+		global:    newTokenBucket(config.global, now),
+		domains:   map[string]*tokenBucket{},
+		lastSweep: now,
+		metrics:   rateLimitMetrics{domains: map[string]domainMetrics{}},
+	}, nil
+}
+
+func (l *rateLimiter) bucketFor(domain string) *tokenBucket {
+	b, ok := l.domains[domain]
+	if !ok {
+		limit, ok := l.config.domains[domain]
+		if !ok {
+			limit = l.config.defaultDomain
+		}
+		b = newTokenBucket(limit, l.clock.Now())
+		l.domains[domain] = b
+	}
+	return b
+}
+
+// sweep drops domain buckets which refilled to full, a new bucket would start the same way
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketSweepEvery {
+		return
+	}
+	l.lastSweep = now
+	for domain, b := range l.domains {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.burst) {
+			delete(l.domains, domain)
+		}
+	}
+}
+
+// metricsKey is the domain itself while there is room (or it has its own limit), otherDomains after that, caller holds the lock
+func (l *rateLimiter) metricsKey(domain string) string {
+	if _, ok := l.metrics.domains[domain]; ok {
+		return domain
+	}
+	if _, ok := l.config.domains[domain]; ok || len(l.metrics.domains) < maxMetricDomains {
+		return domain
+	}
+	return otherDomains
+}
+
+/*
+wait blocks till both buckets give a token for this recipient, or ctx is done
+tokens are only taken when both have one, so waiting on gmail does not eat the global tokens
+*/
+func (l *rateLimiter) wait(ctx context.Context, recipient string) error {
+	domain := strings.ToLower(domainOf(recipient))
+	l.mu.Lock()
+	l.metrics.queued++
+	var throttledAt time.Time //zero -> did not wait yet
+	for {
+		now := l.clock.Now()
+		l.sweep(now)
+		bucket := l.bucketFor(domain)
+		delay := max(l.global.waitFor(now), bucket.waitFor(now))
+		if delay == 0 {
+			l.global.tokens--
+			bucket.tokens--
+			if !throttledAt.IsZero() {
+				l.metrics.waited += now.Sub(throttledAt)
+			}
+			l.metrics.queued--
+			l.metrics.sent++
+			key := l.metricsKey(domain)
+			dm := l.metrics.domains[key]
+			dm.sent++
+			l.metrics.domains[key] = dm
+			l.mu.Unlock()
+			return nil
+		}
+		if throttledAt.IsZero() {
+			throttledAt = now
+			l.metrics.throttled++
+			key := l.metricsKey(domain)
+			dm := l.metrics.domains[key]
+			dm.throttled++
+			l.metrics.domains[key] = dm
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-l.clock.After(delay):
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.metrics.waited += l.clock.Now().Sub(throttledAt)
+			l.metrics.queued--
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+		l.mu.Lock() //other senders could have taken the token meanwhile, so check again
+	}
+}
+
+func (l *rateLimiter) snapshot() rateLimitMetrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := l.metrics
+	m.domains = make(map[string]domainMetrics, len(l.metrics.domains))
+	for d, dm := range l.metrics.domains {
+		m.domains[d] = dm
+	}
+	return m
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var rateLimitTestNow = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func onePerSecond() rateLimitConfig {
+	return rateLimitConfig{
+		global:        rateLimit{perSecond: 1, burst: 1},
+		defaultDomain: rateLimit{perSecond: 10, burst: 10},
+	}
+}
+
+// waitUntil polls cond, goroutines under test run on real time even when the clock is fake
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waiting tells how many After calls are still waiting on the fake clock
+func (c *fakeClock) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func TestRateLimiterRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*rateLimitConfig)
+	}{
+		{"zero global rate", func(c *rateLimitConfig) { c.global.perSecond = 0 }},
+		{"negative global rate", func(c *rateLimitConfig) { c.global.perSecond = -1 }},
+		{"zero global burst", func(c *rateLimitConfig) { c.global.burst = 0 }},
+		{"zero default domain burst", func(c *rateLimitConfig) { c.defaultDomain.burst = 0 }},
+		{"zero domain rate", func(c *rateLimitConfig) { c.domains["gmail.com"] = rateLimit{perSecond: 0, burst: 3} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultRateLimitConfig()
+			tt.change(&config)
+			if _, err := newRateLimiter(config, newFakeClock(rateLimitTestNow)); !errors.Is(err, errInvalidRateLimit) {
+				t.Errorf("err = %v, want errInvalidRateLimit", err)
+			}
+		})
+	}
+	if _, err := newRateLimiter(defaultRateLimitConfig(), nil); err != nil {
+		t.Errorf("default config: %v", err)
+	}
+}
+
+func TestRateLimiterCountsRealWait(t *testing.T) {
+	fake := newFakeClock(rateLimitTestNow)
+	limiter, err := newRateLimiter(onePerSecond(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.wait(context.Background(), "1@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- limiter.wait(ctx, "2@gmail.com") }()
+	waitUntil(t, "second email to be throttled", func() bool { return limiter.snapshot().throttled == 1 })
+
+	fake.Advance(200 * time.Millisecond) //token needs 1s, give up after 200ms
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	m := limiter.snapshot()
+	if m.waited != 200*time.Millisecond {
+		t.Errorf("waited = %v, want the 200ms really waited, not the 1s planned", m.waited)
+	}
+	if m.queued != 0 || m.sent != 1 {
+		t.Errorf("queued=%d sent=%d, want 0 and 1", m.queued, m.sent)
+	}
+}
+
+func TestRateLimiterDropsIdleBuckets(t *testing.T) {
+	fake := newFakeClock(rateLimitTestNow)
+	limiter, err := newRateLimiter(defaultRateLimitConfig(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"1@gmail.com", "2@example.com", "3@yahoo.com"} {
+		if err := limiter.wait(context.Background(), to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(limiter.domains); n != 3 {
+		t.Fatalf("buckets = %d, want 3", n)
+	}
+
+	fake.Advance(bucketSweepEvery)
+	if err := limiter.wait(context.Background(), "4@outlook.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limiter.domains["outlook.com"]; !ok || len(limiter.domains) != 1 {
+		t.Errorf("buckets after sweep = %v, want only outlook.com", limiter.domains)
+	}
+	if m := limiter.snapshot(); m.domains["gmail.com"].sent != 1 {
+		t.Errorf("gmail.com metrics lost with its bucket: %+v", m.domains)
+	}
+}
+
+// every recipient domain must not keep its own metrics line forever, totals still add up
+func TestRateLimiterCapsDomainMetrics(t *testing.T) {
+	fake := newFakeClock(rateLimitTestNow)
+	config := defaultRateLimitConfig()
+	config.global = rateLimit{perSecond: 1000, burst: 1000}
+	limiter, err := newRateLimiter(config, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(to string) {
+		t.Helper()
+		if err := limiter.wait(context.Background(), to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < maxMetricDomains+50; i++ {
+		send(fmt.Sprintf("user@spam%d.com", i))
+	}
+	send("user@spam0.com")   //already has a line, keeps it
+	send("late@example.com") //own limit, always its own line
+
+	m := limiter.snapshot()
+	if n := len(m.domains); n > maxMetricDomains+2 {
+		t.Errorf("metrics keep %d domains, want at most %d", n, maxMetricDomains+2)
+	}
+	if got := m.domains["spam0.com"].sent; got != 2 {
+		t.Errorf("spam0.com sent = %d, want 2", got)
+	}
+	if got := m.domains["example.com"].sent; got != 1 {
+		t.Errorf("example.com sent = %d, want its own line", got)
+	}
+	if got := m.domains[otherDomains].sent; got != 50 {
+		t.Errorf("%s sent = %d, want 50", otherDomains, got)
+	}
+	total := 0
+	for _, dm := range m.domains {
+		total += dm.sent
+	}
+	if total != m.sent {
+		t.Errorf("domain lines add up to %d, total sent is %d", total, m.sent)
+	}
+}
+
+func TestDurableEmailSenderWaitsForLimiter(t *testing.T) {
+	fake := newFakeClock(rateLimitTestNow)
+	opts := defaultEmailQueueOptions()
+	opts.clock = fake
+	q, err := openEmailQueue(filepath.Join(t.TempDir(), emailJournal), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	limiter, err := newRateLimiter(onePerSecond(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.enqueue("1@gmail.com", "hello", "hi")
+	q.enqueue("2@gmail.com", "hello", "hi")
+
+	sent := make(chan string, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go durableEmailSender(ctx, q, limiter, func(job emailJob) error {
+		sent <- job.To
+		return nil
+	})
+
+	<-sent
+	waitUntil(t, "second email to wait for a token", func() bool { return fake.waiting() == 1 })
+	select {
+	case to := <-sent:
+		t.Fatalf("%s was sent before the limiter gave a token", to)
+	default:
+	}
+	fake.Advance(time.Second)
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second email not sent after the token came")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+/*
+emails are made from named templates, every name has 3 parts:
+- name.subject -> text/template
+- name.text    -> text/template, plain text part
+- name.html    -> html/template, escapes the data so customer name like <script> can not break the mail
+both text and html go in one multipart/alternative message, mail client shows the one it can
+order status change -> statusTemplates picks the shipping notice for that status
+missingkey=error on both, a typo like .Vars.cupon fails the render instead of mailing an empty line
+optional vars are read with index (index .Vars "coupon"), index gives "" for a missing key
+*/
+
+var (
+	errNoTemplateForStatus = errors.New("no email template for order status")
+	errNoRecipient         = errors.New("email has no recipient address")
+)
+
+type orderItem struct {
+	Name  string
+	Qty   int
+	Price string
+}
+
+type orderDetails struct {
+	ID          string
+	Status      OrderStatus
+	Items       []orderItem
+	Total       string
+	TrackingURL string
+	ETA         time.Time
+}
+
+type emailRecipient struct {
+	Email string
+	Name  string
+	Vars  map[string]string //per recipient, like coupon code or preferred language
+}
+
+// templateData is what templates see, fields are exported because template package reads them by reflection
+type templateData struct {
+	Recipient emailRecipient
+	Order     orderDetails
+	Vars      map[string]string //campaign vars, recipient vars win over them
+}
+
+func personalize(recipient emailRecipient, order orderDetails, campaign map[string]string) templateData {
+	vars := make(map[string]string, len(campaign)+len(recipient.Vars))
+	for k, v := range campaign {
+		vars[k] = v
+	}
+	for k, v := range recipient.Vars {
+		vars[k] = v
+	}
+	return templateData{Recipient: recipient, Order: order, Vars: vars}
+}
+
+var templateFuncs = map[string]any{
+	"date": func(t time.Time) string { return t.Format("Mon, 02 Jan 2006") },
+	"firstName": func(name string) string {
+		if first, _, ok := strings.Cut(name, " "); ok {
+			return first
+		}
+		return name
+	},
+}
+
+const builtinTextTemplates = `
+{{define "order_confirmation.subject"}}Order {{.Order.ID}} confirmed{{end}}
+{{define "order_confirmation.text"}}Hi {{firstName .Recipient.Name}},
+
+thanks for your order {{.Order.ID}}.
+{{range .Order.Items}}
+  {{.Qty}} x {{.Name}}  {{.Price}}{{end}}
+
+Total: {{.Order.Total}}
+{{with index .Vars "coupon"}}
+Use {{.}} for 10% off your next order.
+{{end}}{{end}}
+
+{{define "order_shipped.subject"}}Your order {{.Order.ID}} is on the way{{end}}
+{{define "order_shipped.text"}}Hi {{firstName .Recipient.Name}},
+
+order {{.Order.ID}} has been shipped and should arrive by {{date .Order.ETA}}.
+Track it here: {{.Order.TrackingURL}}
+{{end}}
+
+{{define "order_delivered.subject"}}Order {{.Order.ID}} delivered{{end}}
+{{define "order_delivered.text"}}Hi {{firstName .Recipient.Name}},
+
+order {{.Order.ID}} was delivered. Enjoy!
+{{end}}
+
+{{define "order_cancelled.subject"}}Order {{.Order.ID}} cancelled{{end}}
+{{define "order_cancelled.text"}}Hi {{firstName .Recipient.Name}},
+
+order {{.Order.ID}} was cancelled, the refund of {{.Order.Total}} is on its way.
+{{end}}
+`
+
+const builtinHTMLTemplates = `
+{{define "order_confirmation.html"}}<p>Hi {{firstName .Recipient.Name}},</p>
+<p>thanks for your order <b>{{.Order.ID}}</b>.</p>
+<table>{{range .Order.Items}}
+<tr><td>{{.Qty}} x {{.Name}}</td><td>{{.Price}}</td></tr>{{end}}
+<tr><td><b>Total</b></td><td><b>{{.Order.Total}}</b></td></tr>
+</table>{{with index .Vars "coupon"}}
+<p>Use <code>{{.}}</code> for 10% off your next order.</p>{{end}}
+{{end}}
+
+{{define "order_shipped.html"}}<p>Hi {{firstName .Recipient.Name}},</p>
+<p>order <b>{{.Order.ID}}</b> has been shipped and should arrive by {{date .Order.ETA}}.</p>
+<p><a href="{{.Order.TrackingURL}}">Track your order</a></p>
+{{end}}
+
+{{define "order_delivered.html"}}<p>Hi {{firstName .Recipient.Name}},</p>
+<p>order <b>{{.Order.ID}}</b> was delivered. Enjoy!</p>
+{{end}}
+
+{{define "order_cancelled.html"}}<p>Hi {{firstName .Recipient.Name}},</p>
+<p>order <b>{{.Order.ID}}</b> was cancelled, the refund of {{.Order.Total}} is on its way.</p>
+{{end}}
+`
+
+// status change -> which template, statuses not here send no mail
+var statusTemplates = map[OrderStatus]string{
+	Confrimed: "order_confirmation",
+	Shipped:   "order_shipped",
+	Delivered: "order_delivered",
+	Cancelled: "order_cancelled",
+}
+
+/*
+html/template can not Parse or Clone once it has executed, so the engine keeps htmlSource which never runs
+addTemplate parses into clones and swaps them in only when all parts parsed, render runs the html copy
+*/
+type templateEngine struct {
+	from string
+
+	mu         sync.RWMutex
+	text       *texttemplate.Template
+	html       *htmltemplate.Template
+	htmlSource *htmltemplate.Template
+}
+
+func newTemplateEngine(from string) (*templateEngine, error) {
+	text, err := texttemplate.New("emails").Funcs(templateFuncs).Option("missingkey=error").Parse(builtinTextTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("text templates: %w", err)
+	}
+	htmlSource, err := htmltemplate.New("emails").Funcs(templateFuncs).Option("missingkey=error").Parse(builtinHTMLTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("html templates: %w", err)
+	}
+	html, err := htmlSource.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("html templates: %w", err)
+	}
+	return &templateEngine{from: from, text: text, html: html, htmlSource: htmlSource}, nil
+}
+
+// addTemplate registers a custom named template, html can be empty for text only mails
+func (e *templateEngine) addTemplate(name string, subject string, text string, html string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	texts, err := e.text.Clone()
+	if err != nil {
+		return err
+	}
+	if _, err := texts.New(name + ".subject").Parse(subject); err != nil {
+		return fmt.Errorf("template %s subject: %w", name, err)
+	}
+	if _, err := texts.New(name + ".text").Parse(text); err != nil {
+		return fmt.Errorf("template %s text: %w", name, err)
+	}
+	htmlSource, err := e.htmlSource.Clone()
+	if err != nil {
+		return err
+	}
+	if html != "" {
+		if _, err := htmlSource.New(name + ".html").Parse(html); err != nil {
+			return fmt.Errorf("template %s html: %w", name, err)
+		}
+	}
+	htmls, err := htmlSource.Clone()
+	if err != nil {
+		return err
+	}
+	e.text, e.html, e.htmlSource = texts, htmls, htmlSource
+	return nil
+}
+
+// names lists every template which has a subject
+func (e *templateEngine) names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var names []string
+	for _, t := range e.text.Templates() {
+		if name, ok := strings.CutSuffix(t.Name(), ".subject"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *templateEngine) render(name string, data templateData) (emailMessage, error) {
+	if data.Recipient.Email == "" {
+		return emailMessage{}, errNoRecipient
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.text.Lookup(name+".subject") == nil {
+		return emailMessage{}, fmt.Errorf("unknown email template %q", name)
+	}
+	var subject, text, html strings.Builder
+	if err := e.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return emailMessage{}, err
+	}
+	if err := e.text.ExecuteTemplate(&text, name+".text", data); err != nil {
+		return emailMessage{}, err
+	}
+	if e.html.Lookup(name+".html") != nil {
+		if err := e.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+			return emailMessage{}, err
+		}
+	}
+	return emailMessage{
+		from:     e.from,
+		to:       []string{data.Recipient.Email},
+		subject:  strings.TrimSpace(subject.String()),
+		textBody: text.String(),
+		htmlBody: html.String(),
+	}, nil
+}
+
+// renderStatusChange gives the shipping notice for the new status
+func (e *templateEngine) renderStatusChange(data templateData) (emailMessage, error) {
+	name, ok := statusTemplates[data.Order.Status]
+	if !ok {
+		return emailMessage{}, fmt.Errorf("%w: %v", errNoTemplateForStatus, data.Order.Status)
+	}
+	return e.render(name, data)
+}
+
+func sampleTemplateData(clock Clock) templateData {
+	return personalize(
+		emailRecipient{Email: "asha@example.com", Name: "Asha Verma", Vars: map[string]string{"coupon": "ASHA10"}},
+		orderDetails{
+			ID:          "ORD-1001",
+			Status:      Confrimed,
+			Items:       []orderItem{{Name: "Notebook", Qty: 2, Price: "₹120.00"}, {Name: "Pen <gel>", Qty: 1, Price: "₹40.00"}},
+			Total:       "₹280.00",
+			TrackingURL: "https://track.example.com/ORD-1001",
+			ETA:         clock.Now().Add(72 * time.Hour),
+		},
+		nil,
+	)
+}
+
+// previewTemplates writes every template as name.txt, name.html and full name.eml so they can be checked in a browser/mail client
+func previewTemplates(e *templateEngine, dir string, data templateData, clock Clock) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, name := range e.names() {
+		msg, err := e.render(name, data)
+		if err != nil {
+			return written, fmt.Errorf("rendering %s: %w", name, err)
+		}
+		files := map[string]string{
+			name + ".txt": "Subject: " + msg.subject + "\n\n" + msg.textBody,
+			name + ".eml": string(buildMessage(msg, clock.Now())),
+		}
+		if msg.htmlBody != "" {
+			files[name+".html"] = msg.htmlBody
+		}
+		for file, content := range files {
+			path := filepath.Join(dir, file)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				return written, err
+			}
+			written = append(written, path)
+		}
+	}
+	sort.Strings(written)
+	return written, nil
+}
+
+func templatesDemo() {
+	fmt.Println("+++++EMAIL TEMPLATES+++++")
+	engine, err := newTemplateEngine("shop@example.com")
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	data := sampleTemplateData(realClock{})
+	data.Recipient.Name = "<script>alert(1)</script> Hacker" //html part escapes it, text part keeps it as is
+
+	for _, status := range []OrderStatus{Confrimed, Prepared, Shipped, Delivered} {
+		data.Order.Status = status
+		msg, err := engine.renderStatusChange(data)
+		if errors.Is(err, errNoTemplateForStatus) {
+			fmt.Println(status, "-> no mail")
+			continue
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+		fmt.Printf("%v -> %q, html escaped: %v\n", status, msg.subject, strings.Contains(msg.htmlBody, "&lt;script&gt;"))
+	}
+
+	//templates already ran above, a custom one can still be added
+	err = engine.addTemplate("order_returned", "Return for {{.Order.ID}} received", "Hi {{firstName .Recipient.Name}}, we got your return.", "<p>Hi {{firstName .Recipient.Name}}, we got your return.</p>")
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if msg, err := engine.render("order_returned", data); err != nil {
+		fmt.Println("error:", err)
+	} else {
+		fmt.Printf("custom template -> %q\n", msg.subject)
+	}
+}
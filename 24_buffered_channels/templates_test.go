@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTemplateEngine(t *testing.T) *templateEngine {
+	t.Helper()
+	engine, err := newTemplateEngine("shop@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestAddTemplateAfterRender(t *testing.T) {
+	engine := newTestTemplateEngine(t)
+	data := sampleTemplateData(newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)))
+	if _, err := engine.render("order_confirmation", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.addTemplate("welcome", "Welcome {{.Recipient.Name}}", "hi", "<p>hi {{.Recipient.Name}}</p>"); err != nil {
+		t.Fatalf("addTemplate after render: %v", err)
+	}
+	msg, err := engine.render("welcome", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.subject != "Welcome Asha Verma" || msg.htmlBody != "<p>hi Asha Verma</p>" {
+		t.Errorf("welcome = %q %q", msg.subject, msg.htmlBody)
+	}
+
+	//a broken html part must not leave the subject and text registered
+	if err := engine.addTemplate("broken", "subject", "text", "{{if}}"); err == nil {
+		t.Fatal("broken html template was accepted")
+	}
+	for _, name := range engine.names() {
+		if name == "broken" {
+			t.Error("half added template is listed")
+		}
+	}
+}
+
+func TestTemplateMissingKeyFails(t *testing.T) {
+	data := sampleTemplateData(newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)))
+	tests := []struct {
+		name string
+		text string
+		html string
+	}{
+		{"text", "{{.Vars.cupon}}", ""},
+		{"html", "ok", "<p>{{.Vars.cupon}}</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestTemplateEngine(t)
+			if err := engine.addTemplate("typo", "subject", tt.text, tt.html); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := engine.render("typo", data); err == nil {
+				t.Error("missing key rendered without error")
+			}
+		})
+	}
+}
+
+func TestTemplateOptionalVars(t *testing.T) {
+	engine := newTestTemplateEngine(t)
+	data := sampleTemplateData(newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)))
+	with, err := engine.render("order_confirmation", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(with.textBody, "ASHA10") || !strings.Contains(with.htmlBody, "ASHA10") {
+		t.Error("coupon missing from the mail")
+	}
+
+	data.Vars = map[string]string{}
+	without, err := engine.render("order_confirmation", data)
+	if err != nil {
+		t.Fatalf("no coupon var: %v", err)
+	}
+	if strings.Contains(without.textBody, "off your next order") {
+		t.Error("coupon line rendered without a coupon")
+	}
+}
+
+func TestRenderWithoutRecipient(t *testing.T) {
+	engine := newTestTemplateEngine(t)
+	if _, err := engine.render("order_confirmation", templateData{}); !errors.Is(err, errNoRecipient) {
+		t.Errorf("err = %v, want errNoRecipient", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+one emailSender is slow, mailer waits for network most of the time
+so N emailSender goroutines read the same channel, channel gives each email to only one of them
+shutdown (ctrl+c / SIGTERM):
+- workers keep draining the channel till it is empty, so mails already queued still go out
+- but only till drainTimeout, after that in flight sends are cancelled and whatever left is abandoned
+- report tells how many sent, failed and abandoned, abandoned ones are printed so nobody loses them
+*/
+
+type emailPoolOptions struct {
+	workers      int
+	drainTimeout time.Duration
+	limiter      *rateLimiter //nil -> no limit
+	mailer       Mailer
+	render       func(to string) (emailMessage, error) //builds the personalized mail for this address
+	clock        Clock
+}
+
+// EMAIL_WORKERS env changes the worker count
+func workersFromEnv(fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv("EMAIL_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+type shutdownReport struct {
+	sent      []string
+	failed    []string
+	abandoned []string
+	timedOut  bool //drain deadline was hit
+}
+
+func (r shutdownReport) String() string {
+	s := fmt.Sprintf("sent=%d failed=%d abandoned=%d", len(r.sent), len(r.failed), len(r.abandoned))
+	if r.timedOut {
+		s += " (drain deadline hit)"
+	}
+	if len(r.abandoned) > 0 {
+		s += " abandoned: " + strings.Join(r.abandoned, ", ")
+	}
+	return s
+}
+
+type emailPool struct {
+	opts emailPoolOptions
+
+	mu       sync.Mutex
+	inFlight map[int]string //worker id -> email it is sending now
+	report   shutdownReport
+}
+
+func newEmailPool(opts emailPoolOptions) *emailPool {
+	if opts.workers <= 0 {
+		opts.workers = 1
+	}
+	if opts.clock == nil {
+		opts.clock = realClock{}
+	}
+	return &emailPool{opts: opts, inFlight: map[int]string{}}
+}
+
+func (p *emailPool) inFlightNow() map[int]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := make(map[int]string, len(p.inFlight))
+	for w, email := range p.inFlight {
+		now[w] = email
+	}
+	return now
+}
+
+func (p *emailPool) record(worker int, email string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inFlight, worker)
+	switch {
+	case err == nil:
+		p.report.sent = append(p.report.sent, email)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		p.report.abandoned = append(p.report.abandoned, email) //cut by drain deadline, never confirmed
+	default:
+		p.report.failed = append(p.report.failed, email)
+	}
+}
+
+func (p *emailPool) deliver(ctx context.Context, worker int, email string) error {
+	p.mu.Lock()
+	p.inFlight[worker] = email
+	p.mu.Unlock()
+
+	//limiter instead of fixed time.Sleep, only waits when global or domain limit is hit
+	if p.opts.limiter != nil {
+		if err := p.opts.limiter.wait(ctx, email); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("worker %d sending email to: %s\n", worker, email)
+	msg, err := p.opts.render(email)
+	if err != nil {
+		return err
+	}
+	return p.opts.mailer.send(ctx, msg)
+}
+
+/*
+run starts the workers and blocks till emailChan is closed and empty, or shutdown drain is over
+cancel of ctx (signal) starts the drain, it does not stop the workers at once
+*/
+func (p *emailPool) run(ctx context.Context, emailChan <-chan string) shutdownReport {
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	defer cancelSends()
+
+	var wg sync.WaitGroup
+	for w := 1; w <= p.opts.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			emailSender(sendCtx, w, emailChan, p)
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		fmt.Println("shutdown signal, draining for", p.opts.drainTimeout)
+		select {
+		case <-finished:
+		case <-p.opts.clock.After(p.opts.drainTimeout):
+			for w, email := range p.inFlightNow() {
+				fmt.Printf("worker %d still sending to %s, cancelling\n", w, email)
+			}
+			cancelSends()
+			<-finished
+			p.mu.Lock()
+			p.report.timedOut = true
+			p.mu.Unlock()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	//emails still sitting in the buffer were never picked up
+	for {
+		select {
+		case email, ok := <-emailChan:
+			if ok {
+				p.report.abandoned = append(p.report.abandoned, email)
+				continue
+			}
+		default:
+		}
+		break
+	}
+	return p.report
+}
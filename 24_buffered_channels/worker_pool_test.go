@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mailerFunc lets a test write the mailer inline
+type mailerFunc func(ctx context.Context, msg emailMessage) error
+
+func (f mailerFunc) send(ctx context.Context, msg emailMessage) error { return f(ctx, msg) }
+
+func plainRender(to string) (emailMessage, error) {
+	return emailMessage{from: "shop@example.com", to: []string{to}, subject: "hi", textBody: "hi"}, nil
+}
+
+func queued(emails ...string) chan string {
+	ch := make(chan string, len(emails))
+	for _, e := range emails {
+		ch <- e
+	}
+	return ch
+}
+
+// sameEmails compares in any order, workers finish in any order
+func sameEmails(got []string, want ...string) bool {
+	got, want = slices.Clone(got), slices.Clone(want)
+	slices.Sort(got)
+	slices.Sort(want)
+	return slices.Equal(got, want)
+}
+
+func TestEmailPoolDrainsChannel(t *testing.T) {
+	emails := []string{"1@gmail.com", "2@gmail.com", "3@yahoo.com", "4@example.com", "5@gmail.com"}
+	pool := newEmailPool(emailPoolOptions{
+		workers:      3,
+		drainTimeout: time.Minute,
+		mailer:       mailerFunc(func(ctx context.Context, msg emailMessage) error { return nil }),
+		render:       plainRender,
+		//no limiter -> no limit, it must not panic
+	})
+	ch := queued(emails...)
+	close(ch)
+
+	report := pool.run(context.Background(), ch)
+	if !sameEmails(report.sent, emails...) || len(report.failed) != 0 || len(report.abandoned) != 0 || report.timedOut {
+		t.Errorf("report = %v, want all %d sent", report, len(emails))
+	}
+}
+
+func TestEmailPoolReportsFailures(t *testing.T) {
+	pool := newEmailPool(emailPoolOptions{
+		workers:      2,
+		drainTimeout: time.Minute,
+		mailer: mailerFunc(func(ctx context.Context, msg emailMessage) error {
+			if msg.to[0] == "bounce@invalid" {
+				return errPermanentFailure
+			}
+			return nil
+		}),
+		render: func(to string) (emailMessage, error) {
+			if to == "nobody@gmail.com" {
+				return emailMessage{}, errors.New("no order details")
+			}
+			return plainRender(to)
+		},
+	})
+	ch := queued("1@gmail.com", "bounce@invalid", "nobody@gmail.com", "2@gmail.com")
+	close(ch)
+
+	report := pool.run(context.Background(), ch)
+	if !sameEmails(report.sent, "1@gmail.com", "2@gmail.com") {
+		t.Errorf("sent = %v", report.sent)
+	}
+	if !sameEmails(report.failed, "bounce@invalid", "nobody@gmail.com") {
+		t.Errorf("failed = %v, want the mailer and the render error", report.failed)
+	}
+	if len(report.abandoned) != 0 {
+		t.Errorf("abandoned = %v, want none", report.abandoned)
+	}
+}
+
+// shutdown while one email is being sent and two wait in the buffer -> all three are reported abandoned
+func TestEmailPoolCancelReportsQueuedAsAbandoned(t *testing.T) {
+	fake := newFakeClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	sending := make(chan string, 1)
+	pool := newEmailPool(emailPoolOptions{
+		workers:      1,
+		drainTimeout: 5 * time.Second,
+		clock:        fake,
+		mailer: mailerFunc(func(ctx context.Context, msg emailMessage) error {
+			select {
+			case sending <- msg.to[0]:
+			default:
+			}
+			<-ctx.Done() //slow server, only the drain deadline stops it
+			return ctx.Err()
+		}),
+		render: plainRender,
+	})
+	ch := queued("1@gmail.com", "2@gmail.com", "3@gmail.com") //never closed, more could come
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := make(chan shutdownReport, 1)
+	go func() { reports <- pool.run(ctx, ch) }()
+	<-sending
+	cancel()
+	waitUntil(t, "drain deadline timer", func() bool { return fake.waiting() == 1 })
+	fake.Advance(5 * time.Second)
+
+	select {
+	case report := <-reports:
+		if !report.timedOut {
+			t.Error("report does not say the drain deadline was hit")
+		}
+		if !sameEmails(report.abandoned, "1@gmail.com", "2@gmail.com", "3@gmail.com") || len(report.sent) != 0 || len(report.failed) != 0 {
+			t.Errorf("report = %v, want all three abandoned", report)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pool did not stop after the drain deadline")
+	}
+}
@@ -22,8 +22,8 @@ func simpleSwitch() {
 	}
 }
 
-func conditionalSwitch() {
-	today := time.Now().Weekday()
+func conditionalSwitch(clock Clock) { //clock instead of time.Now() so we can check the weekend case on any day
+	today := clock.Now().Weekday()
 	// fmt.Println(today)
 	switch today {
 	case time.Sunday, time.Saturday:
@@ -51,6 +51,7 @@ func whoAmI(i interface{}) { //interface means any type , i can be any type
 }
 func main() {
 	simpleSwitch()
-	conditionalSwitch()
+	conditionalSwitch(realClock{})
+	conditionalSwitch(newFakeClock(time.Date(2025, 1, 4, 10, 0, 0, 0, time.UTC))) //4 jan 2025 was saturday
 	whoAmI("hello")
 }
@@ -0,0 +1,3 @@
+module learngo
+
+go 1.22
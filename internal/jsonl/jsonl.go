@@ -0,0 +1,93 @@
+// Package jsonl holds the JSON-lines journal helpers shared by the lessons
+// which keep their state in an append-only file (orders, events, idempotency keys, email queue).
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+every journal writes one record as `json + "\n"` with a single Write
+a crash in the middle of that Write leaves a torn last line, without the "\n"
+Replay treats only that last line specially:
+- it still decodes -> only the "\n" was lost, we add it back
+- it does not decode -> half written record, we cut it off, it was never acknowledged to anybody
+a broken line in the middle (it has its "\n") is real corruption and stays an error
+*/
+
+// Replay calls decode for every line of f from the start, f must be opened with os.O_RDWR
+func Replay(f *os.File, decode func(line []byte) error) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				return nil
+			}
+			return repairTornLine(f, offset, line, decode)
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if err := decode(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+		offset += int64(len(line))
+	}
+}
+
+func repairTornLine(f *os.File, offset int64, line []byte, decode func([]byte) error) error {
+	if decode(line) == nil {
+		_, err := f.Write([]byte("\n")) //O_APPEND -> goes to the end
+		return err
+	}
+	return f.Truncate(offset)
+}
+
+// Append writes one record as a single line
+func Append(w io.Writer, line []byte) error {
+	_, err := w.Write(append(line, '\n'))
+	return err
+}
+
+/*
+Compact replaces the file at path with the lines given by write
+new file is written next to it and renamed over it, rename is atomic so a crash leaves either the old or the new file
+callers must reopen their *os.File afterwards, the old handle still points at the replaced file
+*/
+func Compact(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //no-op after a successful rename
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,85 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func replayAll(t *testing.T, path string) ([]int, error) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []int
+	err = Replay(f, func(line []byte) error {
+		var n int
+		if err := json.Unmarshal(line, &n); err != nil {
+			return err
+		}
+		got = append(got, n)
+		return nil
+	})
+	return got, err
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []int
+		after   string //file content after replay
+		wantErr bool
+	}{
+		{"clean", "1\n2\n", []int{1, 2}, "1\n2\n", false},
+		{"empty", "", nil, "", false},
+		{"torn half record is cut", "1\n2\n{\"ha", []int{1, 2}, "1\n2\n", false},
+		{"lost newline is added back", "1\n2", []int{1, 2}, "1\n2\n", false},
+		{"corrupt middle line", "1\nxx\n2\n", []int{1}, "1\nxx\n2\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "j.jsonl")
+			os.WriteFile(path, []byte(tt.content), 0o644)
+			got, err := replayAll(t, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+			after, _ := os.ReadFile(path)
+			if string(after) != tt.after {
+				t.Errorf("file after replay = %q, want %q", after, tt.after)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "j.jsonl")
+	os.WriteFile(path, []byte("1\n2\n3\n"), 0o644)
+	err := Compact(path, func(w io.Writer) error {
+		return Append(w, []byte("3"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(path)
+	if string(after) != "3\n" {
+		t.Errorf("after compact = %q, want %q", after, "3\n")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temp file left behind: %v", entries)
+	}
+}
@@ -0,0 +1,222 @@
+// Package money is the integer Money type shared by the lessons which handle payments and orders,
+// it used to be copied into every lesson (16_structs, 17_struct_embedding, 18_interfaces).
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+float32 can not store most decimal numbers exactly, 50.09 is really 50.090000152...
+with money these small errors add up, so we store money as int64 of minor units (paise, cents)
+plus the ISO-4217 currency code, all maths is integer maths so nothing gets lost
+fields stay unexported so a Money is only made by Parse or FromMinor, Minor/Currency read them back for the wire formats
+*/
+
+type Money struct {
+	minor    int64  //amount in the smallest unit, 5009 paise = 50.09 INR
+	currency string //ISO-4217 code like INR, USD
+}
+
+// number of digits after the decimal point for each currency we support
+var currencyExponents = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+}
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	ErrOverflow        = errors.New("money amount overflows int64 minor units") //int64 paise is ~92 lakh crore rupees, past that we error instead of wrapping around
+)
+
+type CurrencyMismatchError struct {
+	Left  string
+	Right string
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("currency mismatch: %s and %s", e.Left, e.Right)
+}
+
+// FromMinor builds Money from minor units, like an amount stored in a journal or sent by a gateway
+func FromMinor(minor int64, currency string) Money {
+	return Money{minor: minor, currency: strings.ToUpper(currency)}
+}
+
+func (m Money) Minor() int64 {
+	return m.minor
+}
+
+func (m Money) Currency() string {
+	return m.currency
+}
+
+// Parse("50.09", "INR") -> 5009 paise, no float is involved while parsing
+func Parse(amount string, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	//only digits are left after the one "-", so "--5" or "-+5" is an error and not 5.00
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > exp || !onlyDigits(whole) || !onlyDigits(frac) {
+		return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+	}
+	frac += strings.Repeat("0", exp-len(frac)) //"5" -> "50" for 2 digit currency
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid %s amount %q: %w", currency, amount, err)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{minor: minor, currency: currency}, nil
+}
+
+func onlyDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// only for constants in code, panics on bad input like regexp.MustCompile
+func MustParse(amount string, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m Money) SameCurrency(other Money) error {
+	if m.currency != other.currency {
+		return &CurrencyMismatchError{Left: m.currency, Right: other.currency}
+	}
+	return nil
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.SameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	sum := m.minor + other.minor
+	if (other.minor > 0 && sum < m.minor) || (other.minor < 0 && sum > m.minor) {
+		return Money{}, fmt.Errorf("%w: %v + %v", ErrOverflow, m, other)
+	}
+	return Money{minor: sum, currency: m.currency}, nil
+}
+
+func (m Money) Subtract(other Money) (Money, error) {
+	if err := m.SameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	diff := m.minor - other.minor
+	if (other.minor < 0 && diff < m.minor) || (other.minor > 0 && diff > m.minor) {
+		return Money{}, fmt.Errorf("%w: %v - %v", ErrOverflow, m, other)
+	}
+	return Money{minor: diff, currency: m.currency}, nil
+}
+
+// multiply by a whole quantity like 3 items of the same price
+func (m Money) Multiply(quantity int64) (Money, error) {
+	product := m.minor * quantity
+	if quantity != 0 && (product/quantity != m.minor || (quantity == -1 && m.minor == math.MinInt64)) {
+		return Money{}, fmt.Errorf("%w: %v x %d", ErrOverflow, m, quantity)
+	}
+	return Money{minor: product, currency: m.currency}, nil
+}
+
+/*
+Allocate splits the money by ratios without losing a single paisa
+100.00 split in 3 equal parts is 33.34, 33.33, 33.33 -> leftover paise go one by one to the first parts
+*/
+func (m Money) Allocate(ratios ...int) ([]Money, error) {
+	total := 0
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, fmt.Errorf("allocate: negative ratio %d", r)
+		}
+		if total > math.MaxInt-r {
+			return nil, fmt.Errorf("allocate: ratios too large: %w", ErrOverflow)
+		}
+		total += r
+	}
+	if total == 0 {
+		return nil, errors.New("allocate: ratios must add up to more than zero")
+	}
+	if m.minor == math.MinInt64 { //-MinInt64 does not fit in int64
+		return nil, fmt.Errorf("allocate %v: %w", m, ErrOverflow)
+	}
+
+	amount := m.minor
+	if amount < 0 {
+		amount = -amount
+	}
+	parts := make([]Money, len(ratios))
+	remainder := amount
+	for i, r := range ratios {
+		if r != 0 && amount > math.MaxInt64/int64(r) { //amount * r would wrap around
+			return nil, fmt.Errorf("allocate %v by ratio %d: %w", m, r, ErrOverflow)
+		}
+		share := amount * int64(r) / int64(total)
+		parts[i] = Money{minor: share, currency: m.currency}
+		remainder -= share
+	}
+	for i := 0; remainder > 0; i++ {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i].minor++
+		remainder--
+	}
+	if m.minor < 0 {
+		for i := range parts {
+			parts[i].minor = -parts[i].minor
+		}
+	}
+	return parts, nil
+}
+
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.minor > 0
+}
+
+// String -> "INR 50.09", fmt uses it automatically
+func (m Money) String() string {
+	exp := currencyExponents[m.currency]
+	sign := ""
+	minor := m.minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s %s%d", m.currency, sign, minor)
+	}
+	unit := int64(1)
+	for i := 0; i < exp; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s %s%d.%0*d", m.currency, sign, minor/unit, exp, minor%unit)
+}
@@ -0,0 +1,197 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+		wantErr  error //nil -> any error when ok is false
+		ok       bool
+	}{
+		{"50.09", "INR", 5009, nil, true},
+		{"50.9", "INR", 5090, nil, true},
+		{"50", "inr", 5000, nil, true},
+		{" 0.01 ", "USD", 1, nil, true},
+		{"-12.50", "EUR", -1250, nil, true},
+		{"-0.01", "INR", -1, nil, true},
+		{"1500", "JPY", 1500, nil, true},
+		{"92233720368547758.07", "INR", math.MaxInt64, nil, true},
+
+		{"--5", "INR", 0, nil, false},
+		{"-+5", "INR", 0, nil, false},
+		{"+5", "INR", 0, nil, false},
+		{"5-", "INR", 0, nil, false},
+		{"1.234", "INR", 0, nil, false},
+		{"1.5", "JPY", 0, nil, false},
+		{".50", "INR", 0, nil, false},
+		{"", "INR", 0, nil, false},
+		{"1e3", "INR", 0, nil, false},
+		{"92233720368547758.08", "INR", 0, nil, false},
+		{"5.00", "XYZ", 0, ErrUnknownCurrency, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.amount, tt.currency)
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("Parse = %v, want error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Minor() != tt.want {
+				t.Errorf("Parse = %d minor units, want %d", got.Minor(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAddAndSubtract(t *testing.T) {
+	largest := FromMinor(math.MaxInt64, "INR")
+	smallest := FromMinor(math.MinInt64, "INR")
+	one := MustParse("0.01", "INR")
+	minusOne := MustParse("-0.01", "INR")
+
+	tests := []struct {
+		name    string
+		do      func() (Money, error)
+		want    int64
+		wantErr error
+	}{
+		{"add", func() (Money, error) { return MustParse("10.50", "INR").Add(MustParse("0.75", "INR")) }, 1125, nil},
+		{"add negative", func() (Money, error) { return MustParse("1.00", "INR").Add(MustParse("-2.50", "INR")) }, -150, nil},
+		{"add overflow", func() (Money, error) { return largest.Add(one) }, 0, ErrOverflow},
+		{"add negative overflow", func() (Money, error) { return smallest.Add(minusOne) }, 0, ErrOverflow},
+		{"subtract", func() (Money, error) { return MustParse("1.00", "INR").Subtract(MustParse("2.50", "INR")) }, -150, nil},
+		{"subtract overflow", func() (Money, error) { return smallest.Subtract(one) }, 0, ErrOverflow},
+		{"subtract negative overflow", func() (Money, error) { return largest.Subtract(minusOne) }, 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.do()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.Minor() != tt.want {
+				t.Errorf("= %d, %v, want %d", got.Minor(), err, tt.want)
+			}
+		})
+	}
+
+	_, err := one.Add(MustParse("0.01", "USD"))
+	var mismatch *CurrencyMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Left != "INR" || mismatch.Right != "USD" {
+		t.Errorf("INR + USD err = %v, want CurrencyMismatchError", err)
+	}
+}
+
+// Multiply takes a whole quantity, the result is exact, there is nothing to round
+func TestMultiply(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		quantity int64
+		want     string
+		wantErr  bool
+	}{
+		{"no float rounding", MustParse("33.33", "INR"), 3, "INR 99.99", false},
+		{"one paisa", MustParse("0.01", "INR"), 3, "INR 0.03", false},
+		{"negative amount", MustParse("-0.05", "USD"), 3, "USD -0.15", false},
+		{"negative quantity", MustParse("2.50", "USD"), -2, "USD -5.00", false},
+		{"zero", MustParse("2.50", "USD"), 0, "USD 0.00", false},
+		{"overflow", MustParse("2.00", "INR"), math.MaxInt64, "", true},
+		{"negative overflow", FromMinor(math.MinInt64, "INR"), -1, "", true},
+		{"min times one", FromMinor(math.MinInt64, "INR"), 1, FromMinor(math.MinInt64, "INR").String(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Multiply(tt.quantity)
+			if tt.wantErr {
+				if !errors.Is(err, ErrOverflow) {
+					t.Errorf("err = %v, want ErrOverflow", err)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("= %v, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  Money
+		ratios  []int
+		want    []int64
+		wantErr bool
+	}{
+		{"equal parts with remainder", MustParse("100.00", "INR"), []int{1, 1, 1}, []int64{3334, 3333, 3333}, false},
+		{"remainder goes to first parts", MustParse("0.05", "INR"), []int{1, 1, 1}, []int64{2, 2, 1}, false},
+		{"by ratio", MustParse("10.00", "INR"), []int{70, 30}, []int64{700, 300}, false},
+		{"zero ratio gets nothing", MustParse("0.10", "INR"), []int{0, 1, 2}, []int64{0, 4, 6}, false},
+		{"negative amount", MustParse("-100.00", "INR"), []int{1, 1, 1}, []int64{-3334, -3333, -3333}, false},
+		{"zero amount", MustParse("0.00", "INR"), []int{1, 2}, []int64{0, 0}, false},
+		{"no ratios", MustParse("1.00", "INR"), nil, nil, true},
+		{"all zero ratios", MustParse("1.00", "INR"), []int{0, 0}, nil, true},
+		{"negative ratio", MustParse("1.00", "INR"), []int{2, -1}, nil, true},
+		{"amount times ratio overflows", FromMinor(math.MaxInt64, "INR"), []int{2, 1}, nil, true},
+		{"min int64", FromMinor(math.MinInt64, "INR"), []int{1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := tt.amount.Allocate(tt.ratios...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Allocate = %v, want error", parts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sum int64
+			for i, p := range parts {
+				if p.Minor() != tt.want[i] || p.Currency() != tt.amount.Currency() {
+					t.Errorf("part %d = %v, want %d minor units", i, p, tt.want[i])
+				}
+				sum += p.Minor()
+			}
+			if sum != tt.amount.Minor() {
+				t.Errorf("parts add up to %d, want %d", sum, tt.amount.Minor())
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{MustParse("50.09", "INR"), "INR 50.09"},
+		{MustParse("-0.05", "USD"), "USD -0.05"},
+		{MustParse("1500", "JPY"), "JPY 1500"},
+		{FromMinor(-1500, "jpy"), "JPY -1500"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package workerpool is the bounded, generic worker pool from the wait groups lesson,
+// kept here so other lessons can import it instead of copying it.
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+go task(i, &wg) starts one goroutine per task, 10k tasks -> 10k goroutines at once, and task can not give back result or error
+Pool[T] (generic, see 20_generic) fixes it:
+- Submit(func(ctx) (T, error)) -> at most Workers tasks run together, Submit waits for a free slot
+- Wait() -> waits like wg.Wait() but also gives results (in submit order or finish order) and the first error
+- FailFast -> first error cancels ctx of all other tasks (same as errgroup)
+- panic in a task comes back as *PanicError with the stack, program does not crash
+- TaskTimeout -> every task gets its own deadline, task must watch ctx.Done() for it to work
+*/
+
+type Options struct {
+	Workers     int           //max tasks running at the same time
+	Ordered     bool          //results in Submit order, otherwise in finish order
+	FailFast    bool          //first error cancels the rest
+	TaskTimeout time.Duration //0 -> no per task deadline
+}
+
+type Result[T any] struct {
+	Index int //position in Submit order
+	Value T
+	Err   error
+}
+
+type PanicError struct {
+	Task  int
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task %d panicked: %v", e.Task, e.Value)
+}
+
+type Pool[T any] struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{} //buffered channel as semaphore, full -> no free worker
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	submitted int
+	results   []Result[T]
+	firstErr  error
+}
+
+func New[T any](ctx context.Context, opts Options) *Pool[T] {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pool[T]{opts: opts, ctx: ctx, cancel: cancel, slots: make(chan struct{}, opts.Workers)}
+}
+
+/*
+Submit runs fn once a slot is free, a cancelled pool never runs fn, it only records ctx.Err() for it
+ctx is checked before and after taking the slot: select picks randomly when a slot and Done are both ready
+*/
+func (p *Pool[T]) Submit(fn func(ctx context.Context) (T, error)) {
+	p.mu.Lock()
+	index := p.submitted
+	p.submitted++
+	p.mu.Unlock()
+
+	if err := p.ctx.Err(); err != nil {
+		p.finish(Result[T]{Index: index, Err: err})
+		return
+	}
+	select {
+	case p.slots <- struct{}{}: //take a slot, blocks while all workers are busy
+	case <-p.ctx.Done(): //cancelled (fail fast or parent ctx), task never runs
+		p.finish(Result[T]{Index: index, Err: p.ctx.Err()})
+		return
+	}
+	if err := p.ctx.Err(); err != nil { //cancelled while we waited for the slot
+		<-p.slots
+		p.finish(Result[T]{Index: index, Err: err})
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+		p.finish(p.run(index, fn))
+	}()
+}
+
+func (p *Pool[T]) run(index int, fn func(ctx context.Context) (T, error)) (result Result[T]) {
+	result.Index = index
+	ctx := p.ctx
+	if p.opts.TaskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.TaskTimeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Err = &PanicError{Task: index, Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	result.Value, result.Err = fn(ctx)
+	if result.Err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && p.ctx.Err() == nil {
+		result.Err = fmt.Errorf("task %d timed out after %s: %w", index, p.opts.TaskTimeout, result.Err)
+	}
+	return result
+}
+
+func (p *Pool[T]) finish(result Result[T]) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = append(p.results, result)
+	if result.Err != nil && p.firstErr == nil && !errors.Is(result.Err, context.Canceled) {
+		p.firstErr = result.Err
+		if p.opts.FailFast {
+			p.cancel()
+		}
+	}
+}
+
+// Wait blocks till every submitted task is done, pool can not be used after it
+func (p *Pool[T]) Wait() ([]Result[T], error) {
+	p.wg.Wait()
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := append([]Result[T](nil), p.results...)
+	if p.opts.Ordered {
+		sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	}
+	return results, p.firstErr
+}
@@ -0,0 +1,135 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sleepy finishes later the smaller n is, so finish order is the reverse of submit order
+func sleepy(n int) func(ctx context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		select {
+		case <-time.After(time.Duration(5-n) * 10 * time.Millisecond):
+			return n, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func TestOrderedResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+	}{
+		{"submit order", true},
+		{"finish order", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := New[int](context.Background(), Options{Workers: 5, Ordered: tt.ordered})
+			for i := 0; i < 5; i++ {
+				pool.Submit(sleepy(i))
+			}
+			results, err := pool.Wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 5 {
+				t.Fatalf("got %d results, want 5", len(results))
+			}
+			for i, r := range results {
+				want := i
+				if !tt.ordered {
+					want = 4 - i
+				}
+				if r.Index != want || r.Value != want {
+					t.Errorf("results[%d] = index %d value %d, want %d", i, r.Index, r.Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFailFastCancelsTheRest(t *testing.T) {
+	bad := errors.New("bad input")
+	var ran atomic.Int32
+	pool := New[int](context.Background(), Options{Workers: 1, FailFast: true})
+	pool.Submit(func(ctx context.Context) (int, error) { return 0, bad })
+	for i := 0; i < 20; i++ {
+		pool.Submit(func(ctx context.Context) (int, error) {
+			ran.Add(1)
+			return i, nil
+		})
+	}
+	results, err := pool.Wait()
+	if !errors.Is(err, bad) {
+		t.Fatalf("err = %v, want the first failure", err)
+	}
+	if n := ran.Load(); n != 0 {
+		t.Errorf("%d tasks ran after the failure, want 0", n)
+	}
+	if len(results) != 21 {
+		t.Fatalf("got %d results, want one for every Submit", len(results))
+	}
+	for _, r := range results[1:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("task %d err = %v, want context.Canceled", r.Index, r.Err)
+		}
+	}
+}
+
+func TestCancelledPoolNeverRunsTasks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool := New[int](ctx, Options{Workers: 4}) //free slots and a done ctx are both ready
+	var ran atomic.Int32
+	for i := 0; i < 100; i++ {
+		pool.Submit(func(ctx context.Context) (int, error) {
+			ran.Add(1)
+			return 0, nil
+		})
+	}
+	pool.Wait()
+	if n := ran.Load(); n != 0 {
+		t.Errorf("%d tasks ran on a cancelled pool", n)
+	}
+}
+
+func TestPanicBecomesError(t *testing.T) {
+	pool := New[int](context.Background(), Options{Workers: 2, Ordered: true})
+	pool.Submit(func(ctx context.Context) (int, error) { return 1, nil })
+	pool.Submit(func(ctx context.Context) (int, error) {
+		var m map[string]int
+		m["boom"] = 1
+		return 0, nil
+	})
+	pool.Submit(func(ctx context.Context) (int, error) { return 3, nil })
+
+	results, err := pool.Wait()
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err = %v, want *PanicError", err)
+	}
+	if pe.Task != 1 || len(pe.Stack) == 0 {
+		t.Errorf("panic error = task %d stack %d bytes, want task 1 with a stack", pe.Task, len(pe.Stack))
+	}
+	if results[0].Value != 1 || results[2].Value != 3 {
+		t.Errorf("other tasks = %d and %d, want 1 and 3", results[0].Value, results[2].Value)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	pool := New[int](context.Background(), Options{Workers: 1, TaskTimeout: 10 * time.Millisecond})
+	pool.Submit(func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	_, err := pool.Wait()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a wrapped context.DeadlineExceeded", err)
+	}
+}